@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// secretPatterns match lines that look like they contain a secret.
+var secretPatterns = []struct {
+	what string
+	re   *regexp.Regexp
+}{
+	{"AWS access key", regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"AWS secret key", regexp.MustCompile(`(?i)aws.{0,20}secret.{0,20}['"][0-9a-zA-Z/+]{40}['"]`)},
+	{"private key", regexp.MustCompile(`-----BEGIN ([A-Z]+ )?PRIVATE KEY( BLOCK)?-----`)},
+	{"GitHub token", regexp.MustCompile(`\b(gh[pousr]_[0-9A-Za-z]{36}|github_pat_[0-9A-Za-z_]{82})\b`)},
+	{"GitLab token", regexp.MustCompile(`\bglpat-[0-9A-Za-z_-]{20}\b`)},
+	{"Slack token", regexp.MustCompile(`\bxox[abposr]-[0-9A-Za-z-]{10,}`)},
+	{"Google API key", regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`)},
+}
+
+// A finding is a file, or a line of one, the audit found.
+type finding struct {
+	path   string
+	line   int    // The line the secret is on, or 0 for a large file.
+	what   string // What was found.
+	commit string // The commit it was found in, when auditing history.
+}
+
+// An auditor looks for large files, and secrets, in the files of repos, and
+// collects what it finds.
+type auditor struct {
+	maxSize int64 // Files larger than this are large.
+	history bool  // Look in every commit, not only HEAD.
+
+	lock     sync.Mutex
+	findings map[string][]finding // By repo dir.
+	repos    map[string]repo
+	audited  int
+}
+
+func newAuditor(maxSize int64, history bool) *auditor {
+	return &auditor{maxSize: maxSize, history: history, findings: map[string][]finding{}, repos: map[string]repo{}}
+}
+
+// audit looks in r, and returns how many findings there were.
+func (a *auditor) audit(r repo) (int, error) {
+	g, err := openRepo(r.dir)
+	if err != nil {
+		return 0, err
+	}
+	head, err := g.Head()
+	if err == plumbing.ErrReferenceNotFound {
+		// Without commits, there is nothing to find.
+		a.lock.Lock()
+		defer a.lock.Unlock()
+		a.audited++
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	var commits []*object.Commit
+	if a.history {
+		iter, err := g.Log(&git.LogOptions{From: head.Hash(), All: true})
+		if err != nil {
+			return 0, err
+		}
+		err = iter.ForEach(func(c *object.Commit) error {
+			commits = append(commits, c)
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		c, err := g.CommitObject(head.Hash())
+		if err != nil {
+			return 0, err
+		}
+		commits = append(commits, c)
+	}
+
+	var found []finding
+	seen := map[plumbing.Hash]bool{} // Blobs already looked in.
+	for _, c := range commits {
+		files, err := c.Files()
+		if err != nil {
+			return 0, err
+		}
+		err = files.ForEach(func(f *object.File) error {
+			if seen[f.Hash] {
+				return nil
+			}
+			seen[f.Hash] = true
+			in := ""
+			if a.history {
+				in = c.Hash.String()[:7]
+			}
+			if f.Size > a.maxSize {
+				found = append(found, finding{path: f.Name, what: "large file (" + humanSize(f.Size) + ")", commit: in})
+				return nil
+			}
+			rd, err := f.Reader()
+			if err != nil {
+				return err
+			}
+			defer rd.Close()
+			for _, fd := range scanSecrets(rd) {
+				fd.path, fd.commit = f.Name, in
+				found = append(found, fd)
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.audited++
+	if len(found) > 0 {
+		a.findings[r.dir] = found
+		a.repos[r.dir] = r
+	}
+	return len(found), nil
+}
+
+// scanSecrets returns the lines of a file that look like they contain a
+// secret. Binary files aren't looked in.
+func scanSecrets(rd io.Reader) []finding {
+	br := bufio.NewReader(rd)
+	if peek, _ := br.Peek(8000); bytes.IndexByte(peek, 0) >= 0 {
+		return nil
+	}
+	var found []finding
+	scan := bufio.NewScanner(br)
+	scan.Buffer(make([]byte, 64*1024), 1<<20)
+	for n := 1; scan.Scan(); n++ {
+		for _, p := range secretPatterns {
+			if p.re.Match(scan.Bytes()) {
+				found = append(found, finding{line: n, what: p.what})
+			}
+		}
+	}
+	return found
+}
+
+// write writes the findings of every repo, ordered by repo path, naming repos
+// with name.
+func (a *auditor) write(w io.Writer, name func(repo) string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	var dirs []string
+	total := 0
+	for dir, found := range a.findings {
+		dirs = append(dirs, dir)
+		total += len(found)
+	}
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		found := a.findings[dir]
+		sort.SliceStable(found, func(i, j int) bool {
+			if found[i].path != found[j].path {
+				return found[i].path < found[j].path
+			}
+			return found[i].line < found[j].line
+		})
+		fmt.Fprintf(w, "%s\n", name(a.repos[dir]))
+		for _, fd := range found {
+			where := fd.path
+			if fd.line > 0 {
+				where += ":" + strconv.Itoa(fd.line)
+			}
+			if fd.commit != "" {
+				where += " in " + fd.commit
+			}
+			fmt.Fprintf(w, "    %-40s  %s\n", where, fd.what)
+		}
+	}
+	fmt.Fprintf(w, "%d findings in %d of %d repos\n", total, len(dirs), a.audited)
+}
+
+// parseSize parses a size in bytes, like 512K, or 5M, with a K, M, or G
+// suffix for KiB, MiB, or GiB.
+func parseSize(s string) (int64, error) {
+	orig, mult := s, int64(1)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		mult = 1 << 10
+	case strings.HasSuffix(s, "M"):
+		mult = 1 << 20
+	case strings.HasSuffix(s, "G"):
+		mult = 1 << 30
+	}
+	if mult > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%q is not a size, like 512K or 5M", orig)
+	}
+	return n * mult, nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// A batcher gathers the repos to run in into batches of n, as xargs does,
+// running each batch as it fills.
+type batcher struct {
+	n   int
+	run func(repos []repo)
+
+	lock  sync.Mutex
+	repos []repo // Not yet run in.
+}
+
+func newBatcher(n int, run func(repos []repo)) *batcher {
+	return &batcher{n: n, run: run}
+}
+
+// add adds r to the batch being gathered, and runs the batch, if it is full.
+func (b *batcher) add(r repo) {
+	b.lock.Lock()
+	b.repos = append(b.repos, r)
+	var full []repo
+	if len(b.repos) >= b.n {
+		full, b.repos = b.repos, nil
+	}
+	b.lock.Unlock()
+	if full != nil {
+		b.run(full)
+	}
+}
+
+// flush runs the last batch, once no more repos will be added.
+func (b *batcher) flush() {
+	b.lock.Lock()
+	last := b.repos
+	b.repos = nil
+	b.lock.Unlock()
+	if len(last) > 0 {
+		b.run(last)
+	}
+}
+
+// batchBanner describes the commands run for repos, as lines of shell.
+func (rn *runner) batchBanner(repos []repo) string {
+	var b strings.Builder
+	for _, cmd := range rn.cmds {
+		b.WriteString(strings.Join(batchCommand(cmd, repos), " ") + "\n")
+	}
+	return b.String()
+}
+
+// batchCommand returns cmd, with the paths of repos in place of its
+// arguments that are pathArg, or if none are, after its arguments.
+func batchCommand(cmd []string, repos []repo) []string {
+	var paths []string
+	for _, r := range repos {
+		paths = append(paths, r.dir)
+	}
+	var run []string
+	replaced := false
+	for _, arg := range cmd {
+		if arg == pathArg {
+			run = append(run, paths...)
+			replaced = true
+		} else {
+			run = append(run, arg)
+		}
+	}
+	if !replaced {
+		run = append(run, paths...)
+	}
+	return run
+}
+
+// executeBatch runs the commands once for all of repos, in order, where
+// git-walk was run, with the repos' paths after their arguments, and returns
+// how that went, which is how it went in each of them. The output is written
+// as if of the first of them.
+func (rn *runner) executeBatch(repos []repo) status {
+	stdout, stderr := new(spool), new(spool)
+	var err error
+	for _, cmd := range rn.cmds {
+		if err = rn.attemptBatch(batchCommand(cmd, repos), stdout, stderr); err != nil {
+			break
+		}
+	}
+	st := failed
+	switch err {
+	case nil:
+		st = succeeded
+	case errCanceled:
+		st = pending
+	}
+	rn.emitSpools(repos[0], stdout, stderr)
+	for _, r := range repos[1:] {
+		rn.emit(r, nil, nil)
+	}
+	return st
+}
+
+// attemptBatch runs cmd once, where git-walk was run, writing its output, and
+// whether it succeeded, to stdout and stderr.
+func (rn *runner) attemptBatch(cmd []string, stdout, stderr *spool) error {
+	var out, errOut io.Writer = stdout, stderr
+	if rn.direct {
+		out, errOut = os.Stdout, os.Stderr
+	}
+	if !rn.quiet {
+		fmt.Fprint(out, paint(rn.colorOut, green, strings.Join(cmd, " ")+"\n"))
+	}
+	run, env := cmd, os.Environ()
+	if rn.forceColor {
+		run, env = forceColor(run, env)
+	}
+	child := exec.Command(run[0], run[1:]...)
+	setCommandLine(child, run)
+	child.Env = env
+	switch {
+	case rn.input != nil:
+		child.Stdin = bytes.NewReader(rn.input)
+	case rn.inherit:
+		child.Stdin = os.Stdin
+	}
+	child.Stdout, child.Stderr = rn.strip(out), rn.strip(errOut)
+	err := rn.run(child)
+	switch err {
+	case nil:
+	case errCanceled:
+		fmt.Fprint(errOut, paint(rn.colorErr, yellow, fmt.Sprintf("`%s` canceled\n", strings.Join(cmd, " "))))
+	case errTimedOut:
+		fmt.Fprint(errOut, paint(rn.colorErr, yellow, fmt.Sprintf("`%s` timed out after %v\n", strings.Join(cmd, " "), rn.timeout)))
+	default:
+		fmt.Fprint(errOut, paint(rn.colorErr, red, fmt.Sprintf("`%s` failed on %v\n", strings.Join(cmd, " "), err)))
+	}
+	return err
+}
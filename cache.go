@@ -0,0 +1,131 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// A discoveryCache is the list of repos found by a previous walk.
+type discoveryCache struct {
+	Root  string       `json:"root"`
+	Time  time.Time    `json:"time"`
+	Repos []cachedRepo `json:"repos"`
+}
+
+type cachedRepo struct {
+	Dir   string `json:"dir"`
+	Super string `json:"super,omitempty"`
+	VCS   string `json:"vcs,omitempty"`
+}
+
+// cachePath returns the path of the cache for walks of root. The key should
+// describe any walk options that affect which repos will be found.
+func cachePath(root, key string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(abs + "\x00" + key))
+	return filepath.Join(dir, "git-walk", fmt.Sprintf("%x.json", sum[:16])), nil
+}
+
+// loadCache returns the repos cached in path, if the cache is younger than
+// ttl.
+func loadCache(path string, ttl time.Duration) ([]repo, bool) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		slog.Debug("cache unread", "path", path, "err", err)
+		return nil, false
+	}
+	var c discoveryCache
+	if err := json.Unmarshal(data, &c); err != nil {
+		slog.Warn("cache unreadable", "path", path, "err", err)
+		return nil, false
+	}
+	if age := time.Since(c.Time); age > ttl {
+		slog.Debug("cache expired", "path", path, "ago", age-ttl)
+		return nil, false
+	}
+	repos := make([]repo, len(c.Repos))
+	for i, r := range c.Repos {
+		repos[i] = repo{dir: r.Dir, super: r.Super, vcs: r.VCS}
+	}
+	return repos, true
+}
+
+// saveCache writes repos found in a walk of root to the cache in path.
+func saveCache(path, root string, repos []repo) error {
+	c := discoveryCache{Root: root, Time: time.Now()}
+	for _, r := range repos {
+		c.Repos = append(c.Repos, cachedRepo{Dir: r.dir, Super: r.super, VCS: r.vcs})
+	}
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	// Write and rename, so concurrent runs never see a partial cache.
+	tmp := fmt.Sprintf("%s.%d", path, os.Getpid())
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// walkCached reports the repos cached for w, or if there are none, walks and
+// caches the repos found.
+func walkCached(w *walker, ttl time.Duration, refresh bool) {
+	key := fmt.Sprintf("%d %d %t %t %s %t %q %t %q", w.maxDepth, w.minDepth,
+		w.follow, w.nested, w.bare, w.submodules, w.excludes, w.oneFS, w.skipFS)
+	// Which VCSs repos are found of matters too, unless only git.
+	if len(w.vcses) > 0 && (len(w.vcses) > 1 || w.vcses[0].name() != "git") {
+		for _, v := range w.vcses {
+			key += " " + v.name()
+		}
+	}
+	path, err := cachePath(w.root, key)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cache failed with %v\n", err)
+		w.walk()
+		return
+	}
+	slog.Debug("cache", "path", path, "refresh", refresh)
+
+	if !refresh {
+		if repos, ok := loadCache(path, ttl); ok {
+			for _, r := range repos {
+				w.found(r)
+			}
+			return
+		}
+	}
+
+	var repos []repo
+	found := w.found
+	w.found = func(r repo) {
+		repos = append(repos, r)
+		found(r)
+	}
+	w.walk()
+	// A walk cut short, or that failed to look in some directories, didn't
+	// find every repo, so isn't remembered as if it had.
+	if w.ctx.Err() != nil || w.failures() > 0 {
+		slog.Debug("cache not saved", "path", path, "errors", w.failures())
+		return
+	}
+	if err := saveCache(path, w.root, repos); err != nil {
+		fmt.Fprintf(os.Stderr, "cache %q failed with %v\n", path, err)
+	}
+}
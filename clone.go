@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// cloneMissing clones, into root, the repos that aren't already there,
+// running up to jobs clones at once, and if perHost is positive, up to that
+// many from the same host. Returns the URLs of those that failed, in order.
+func cloneMissing(ctx context.Context, root string, repos []hostedRepo, jobs, perHost int, quiet bool) []string {
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	var failures []string
+	sem := make(chan struct{}, jobs)
+	hosts := map[string]chan struct{}{}
+	for _, r := range repos {
+		dir := filepath.Join(root, filepath.FromSlash(r.path))
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			slog.Debug("skip", "repo", dir, "reason", "already cloned")
+			continue
+		}
+		var slots chan struct{}
+		if host := urlHost(r.url); perHost > 0 && host != "" {
+			if slots = hosts[host]; slots == nil {
+				slots = make(chan struct{}, perHost)
+				hosts[host] = slots
+			}
+		}
+		wg.Add(1)
+		go func(r hostedRepo, dir string) {
+			defer wg.Done()
+			// Clones wait for a slot of their host first, so they don't keep
+			// those of other hosts from using theirs.
+			if slots != nil {
+				select {
+				case slots <- struct{}{}:
+					defer func() { <-slots }()
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				return
+			}
+			out, err := cloneInto(ctx, r.url, dir)
+			output.Lock()
+			defer output.Unlock()
+			if !quiet {
+				fmt.Printf("git clone %s %s\n", r.url, dir)
+			}
+			if err != nil {
+				os.Stderr.Write(out)
+				fmt.Fprintf(os.Stderr, "clone %q failed with %v\n", r.url, err)
+				lock.Lock()
+				failures = append(failures, r.url)
+				lock.Unlock()
+			}
+		}(r, dir)
+	}
+	wg.Wait()
+	sort.Strings(failures)
+	return failures
+}
+
+// cloneInto clones url into dir, returning git's output.
+func cloneInto(ctx context.Context, url, dir string) ([]byte, error) {
+	if err := os.MkdirAll(filepath.Dir(dir), 0777); err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	git := commandContext(ctx, "git", "clone", "--quiet", url, dir)
+	git.Stdout, git.Stderr = &out, &out
+	err := runGroup(git)
+	return out.Bytes(), err
+}
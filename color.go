@@ -0,0 +1,115 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// ANSI colors of the lines written about commands, by how they went.
+const (
+	red    = "31"
+	green  = "32"
+	yellow = "33"
+)
+
+// paint colors line, but for its newline, if color is true.
+func paint(color bool, code, line string) string {
+	if !color {
+		return line
+	}
+	text := strings.TrimSuffix(line, "\n")
+	return "\x1b[" + code + "m" + text + "\x1b[0m" + line[len(text):]
+}
+
+// useColor decides, for --color=when, whether to color what is written to f:
+// always, never, or if f is a terminal and $NO_COLOR isn't set.
+func useColor(when string, f *os.File) bool {
+	switch when {
+	case "always":
+		enableEscapes(f)
+		return true
+	case "never":
+		return false
+	}
+	if os.Getenv("NO_COLOR") != "" || !term.IsTerminal(int(f.Fd())) {
+		return false
+	}
+	enableEscapes(f)
+	return true
+}
+
+// forceColor returns cmd, made to color its output even though it isn't
+// written to a terminal: git is given color.ui=always, and the environment
+// that other tools check for forced color.
+func forceColor(cmd []string, env []string) ([]string, []string) {
+	if len(cmd) > 0 && programName(cmd[0]) == "git" {
+		cmd = append([]string{cmd[0], "-c", "color.ui=always"}, cmd[1:]...)
+	}
+	env = append(env, "CLICOLOR_FORCE=1", "FORCE_COLOR=1")
+	// Also for git run by scripts, unless config is already given this way.
+	if os.Getenv("GIT_CONFIG_COUNT") == "" {
+		env = append(env, "GIT_CONFIG_COUNT=1", "GIT_CONFIG_KEY_0=color.ui", "GIT_CONFIG_VALUE_0=always")
+	}
+	return cmd, env
+}
+
+// programName returns the name of the program run as cmd, without any .exe.
+func programName(cmd string) string {
+	return strings.TrimSuffix(filepath.Base(cmd), ".exe")
+}
+
+// A stripWriter writes to w without ANSI escape sequences, even those split
+// between writes.
+type stripWriter struct {
+	w     io.Writer
+	state int
+}
+
+// States of a stripWriter, within an escape sequence.
+const (
+	inText = iota
+	inEscape
+	inCSI // A control sequence, like a color, ending with a letter.
+	inOSC // An operating system command, ending with BEL or ESC \.
+)
+
+func (s *stripWriter) Write(b []byte) (int, error) {
+	kept := make([]byte, 0, len(b))
+	for _, c := range b {
+		switch s.state {
+		case inText:
+			if c == 0x1b {
+				s.state = inEscape
+			} else {
+				kept = append(kept, c)
+			}
+		case inEscape:
+			switch c {
+			case '[':
+				s.state = inCSI
+			case ']':
+				s.state = inOSC
+			default:
+				s.state = inText
+			}
+		case inCSI:
+			if c >= 0x40 && c <= 0x7e {
+				s.state = inText
+			}
+		case inOSC:
+			if c == 0x07 {
+				s.state = inText
+			} else if c == 0x1b {
+				s.state = inEscape
+			}
+		}
+	}
+	if _, err := s.w.Write(kept); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
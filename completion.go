@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pborman/getopt/v2"
+)
+
+// completionShells are the shells completion scripts can be written for.
+var completionShells = map[string]func(w io.Writer, c *completion){
+	"bash": writeBash,
+	"zsh":  writeZsh,
+	"fish": writeFish,
+}
+
+// An option, as completed.
+type completeOption struct {
+	short  string   // Without the -, or "".
+	long   string   // Without the --, or "".
+	arg    string   // The name of its argument, or "" if it takes none.
+	desc   string   // What it does, in a line.
+	values []string // The values of its argument, if known.
+	kind   string   // "dir", "file", or "group", if its argument is one.
+}
+
+// A completion is the commands, and the options, of git-walk, to complete.
+type completion struct {
+	global   []completeOption
+	commands []string
+	options  map[string][]completeOption // By command.
+}
+
+// Options whose arguments are paths to complete.
+var completeKinds = map[string]string{
+	"where":         "dir",
+	"log-dir":       "dir",
+	"results-dir":   "dir",
+	"from-file":     "file",
+	"from-mrconfig": "file",
+	"output":        "file",
+	"from":          "file",
+	"into":          "dir",
+	"config":        "file",
+	"token-file":    "file",
+	"group":         "group",
+}
+
+// groupNames is the command the completion scripts run to list the groups of
+// the config file.
+const groupNames = "git-walk completion groups 2>/dev/null"
+
+func newCompletion(global *getopt.Set, sub func(name string) *getopt.Set) *completion {
+	c := &completion{global: optionsOf(global), commands: commands, options: map[string][]completeOption{}}
+	for _, name := range commands {
+		c.options[name] = optionsOf(sub(name))
+	}
+	for i, o := range c.global {
+		if o.long == "format" {
+			for f := range reportFormats {
+				c.global[i].values = append(c.global[i].values, f)
+			}
+			sort.Strings(c.global[i].values)
+		}
+	}
+	return c
+}
+
+var (
+	optionDefault = regexp.MustCompile(`\s*\[[^]]*\]$`)
+	spaces        = regexp.MustCompile(`\s+`)
+)
+
+// optionsOf returns the options of set, as they are described in its usage.
+func optionsOf(set *getopt.Set) []completeOption {
+	var b bytes.Buffer
+	set.PrintOptions(&b)
+	var opts []completeOption
+	for _, line := range strings.Split(b.String(), "\n") {
+		text := strings.TrimSpace(line)
+		if !strings.HasPrefix(text, "-") || len(line)-len(strings.TrimLeft(line, " ")) > getopt.HelpColumn/2 {
+			// The rest of the last option's description.
+			if len(opts) > 0 && text != "" {
+				opts[len(opts)-1].desc += " " + text
+			}
+			continue
+		}
+		names, desc, _ := strings.Cut(text, "  ")
+		o := completeOption{desc: strings.TrimSpace(desc)}
+		for _, name := range strings.Split(names, ", ") {
+			if strings.HasPrefix(name, "--") {
+				o.long, o.arg, _ = strings.Cut(name[2:], "=")
+			} else {
+				o.short, o.arg, _ = strings.Cut(name[1:], " ")
+			}
+		}
+		opts = append(opts, o)
+	}
+	for i := range opts {
+		o := &opts[i]
+		o.desc = optionDefault.ReplaceAllString(strings.ReplaceAll(o.desc, "`", ""), "")
+		o.desc = strings.TrimSpace(spaces.ReplaceAllString(o.desc, " "))
+		if strings.Contains(o.arg, "|") {
+			o.values = strings.Split(o.arg, "|")
+		}
+		o.kind = completeKinds[o.long]
+	}
+	return opts
+}
+
+// names returns the options, as they are written.
+func names(opts []completeOption) []string {
+	var names []string
+	for _, o := range opts {
+		if o.short != "" {
+			names = append(names, "-"+o.short)
+		}
+		if o.long != "" {
+			names = append(names, "--"+o.long)
+		}
+	}
+	return names
+}
+
+func writeBash(w io.Writer, c *completion) {
+	fmt.Fprintf(w, `# bash completion for git-walk, and git walk.
+_git_walk() {
+	local cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]}
+	local i=1 cmd=
+	if [[ ${COMP_WORDS[0]} == git && ${COMP_WORDS[1]} == walk ]]; then
+		i=2
+	fi
+	for ((; i < COMP_CWORD; i++)); do
+		case ${COMP_WORDS[i]} in
+		--) return ;;
+		%s) cmd=${COMP_WORDS[i]}; break ;;
+		esac
+	done
+	case $cmd in
+`, strings.Join(c.commands, "|"))
+	sets := append([]string{""}, c.commands...)
+	for _, name := range sets {
+		opts := c.global
+		if name != "" {
+			opts = c.options[name]
+		}
+		fmt.Fprintf(w, "\t%q)\n\t\tcase $prev in\n", name)
+		for _, o := range opts {
+			if o.arg == "" {
+				continue
+			}
+			words := strings.Join(names([]completeOption{o}), "|")
+			switch {
+			case o.kind == "dir":
+				fmt.Fprintf(w, "\t\t%s) COMPREPLY=($(compgen -d -- \"$cur\")); return ;;\n", words)
+			case o.kind == "file":
+				fmt.Fprintf(w, "\t\t%s) COMPREPLY=($(compgen -f -- \"$cur\")); return ;;\n", words)
+			case o.kind == "group":
+				fmt.Fprintf(w, "\t\t%s) COMPREPLY=($(compgen -W \"$(%s)\" -- \"$cur\")); return ;;\n", words, groupNames)
+			case len(o.values) > 0:
+				fmt.Fprintf(w, "\t\t%s) COMPREPLY=($(compgen -W %q -- \"$cur\")); return ;;\n", words, strings.Join(o.values, " "))
+			default:
+				fmt.Fprintf(w, "\t\t%s) return ;;\n", words)
+			}
+		}
+		fmt.Fprintf(w, "\t\tesac\n")
+		words := strings.Join(names(opts), " ")
+		if name == "" {
+			fmt.Fprintf(w, "\t\tif [[ $cur == -* ]]; then\n\t\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n", words)
+			fmt.Fprintf(w, "\t\telse\n\t\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n\t\tfi\n", strings.Join(c.commands, " "))
+		} else {
+			fmt.Fprintf(w, "\t\t[[ $cur == -* ]] && COMPREPLY=($(compgen -W %q -- \"$cur\"))\n", words)
+		}
+		fmt.Fprintf(w, "\t\t;;\n")
+	}
+	fmt.Fprintf(w, "\tesac\n}\ncomplete -F _git_walk git-walk\n")
+}
+
+// zshQuote quotes s as a word for zsh, in single quotes.
+func zshQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// zshSpecs returns the _arguments specs of opts.
+func zshSpecs(opts []completeOption) []string {
+	var specs []string
+	for _, o := range opts {
+		desc := "[" + strings.NewReplacer("[", `\[`, "]", `\]`).Replace(o.desc) + "]"
+		var arg string
+		if o.arg != "" {
+			action := ""
+			switch {
+			case o.kind == "dir":
+				action = "_files -/"
+			case o.kind == "file":
+				action = "_files"
+			case o.kind == "group":
+				action = `{compadd - ${(f)"$(` + groupNames + `)"}}`
+			case len(o.values) > 0:
+				action = "(" + strings.Join(o.values, " ") + ")"
+			}
+			arg = ":" + strings.ReplaceAll(o.arg, ":", `\:`) + ":" + action
+		}
+		short, long := "-"+o.short, "--"+o.long
+		if o.arg != "" {
+			short, long = short+"+", long+"="
+		}
+		switch {
+		case o.short != "" && o.long != "":
+			specs = append(specs, fmt.Sprintf("'(-%s --%s)'{%s,%s}%s",
+				o.short, o.long, short, long, zshQuote(desc+arg)))
+		case o.short != "":
+			specs = append(specs, zshQuote(short+desc+arg))
+		default:
+			specs = append(specs, zshQuote(long+desc+arg))
+		}
+	}
+	return specs
+}
+
+func writeZsh(w io.Writer, c *completion) {
+	fmt.Fprintf(w, "#compdef git-walk\n\n_git-walk() {\n")
+	fmt.Fprintf(w, "\tlocal curcontext=$curcontext state line\n\ttypeset -A opt_args\n\n")
+	fmt.Fprintf(w, "\t_arguments -C -S \\\n")
+	for _, spec := range zshSpecs(c.global) {
+		fmt.Fprintf(w, "\t\t%s \\\n", spec)
+	}
+	fmt.Fprintf(w, "\t\t'1:command:(%s)' \\\n\t\t'*::arg:->args'\n\n", strings.Join(c.commands, " "))
+	fmt.Fprintf(w, "\t[[ $state == args ]] || return\n\tcase $line[1] in\n")
+	for _, name := range c.commands {
+		fmt.Fprintf(w, "\t%s)\n\t\t_arguments -S \\\n", name)
+		for _, spec := range zshSpecs(c.options[name]) {
+			fmt.Fprintf(w, "\t\t\t%s \\\n", spec)
+		}
+		fmt.Fprintf(w, "\t\t\t'*::command:_normal'\n\t\t;;\n")
+	}
+	fmt.Fprintf(w, "\tesac\n}\n\n_git-walk \"$@\"\n")
+}
+
+// fishQuote quotes s as a word for fish, in single quotes.
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
+
+func writeFish(w io.Writer, c *completion) {
+	cmds := strings.Join(c.commands, " ")
+	fmt.Fprintf(w, "# fish completion for git-walk\ncomplete -c git-walk -f\n")
+	fmt.Fprintf(w, "complete -c git-walk -n 'not __fish_seen_subcommand_from %s' -a '%s'\n", cmds, cmds)
+	write := func(cond string, opts []completeOption) {
+		for _, o := range opts {
+			fmt.Fprintf(w, "complete -c git-walk -n %s", fishQuote(cond))
+			if o.short != "" {
+				fmt.Fprintf(w, " -s %s", o.short)
+			}
+			if o.long != "" {
+				fmt.Fprintf(w, " -l %s", o.long)
+			}
+			switch {
+			case o.kind == "dir":
+				fmt.Fprintf(w, " -x -a '(__fish_complete_directories)'")
+			case o.kind == "file":
+				fmt.Fprintf(w, " -r -F")
+			case o.kind == "group":
+				fmt.Fprintf(w, " -x -a %s", fishQuote("("+groupNames+")"))
+			case len(o.values) > 0:
+				fmt.Fprintf(w, " -x -a %s", fishQuote(strings.Join(o.values, " ")))
+			case o.arg != "":
+				fmt.Fprintf(w, " -x")
+			}
+			fmt.Fprintf(w, " -d %s\n", fishQuote(o.desc))
+		}
+	}
+	write("not __fish_seen_subcommand_from "+cmds, c.global)
+	for _, name := range c.commands {
+		write("__fish_seen_subcommand_from "+name, c.options[name])
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A config is what is read from the config file, written as YAML.
+type config struct {
+	path string // Where it was read from, or "" if there was none.
+
+	// Groups of repos, by name, each a list of path patterns, matched as
+	// --match patterns are, or paths, if they begin with / or ~/.
+	Groups map[string][]string `yaml:"groups"`
+
+	// Settings of particular repos, applied in order, the later overriding
+	// the earlier.
+	Repos []*repoOverride `yaml:"repos"`
+
+	// Commands, by name, to run as git-walk NAME, split into words.
+	Aliases map[string]string `yaml:"aliases"`
+	aliases map[string][]string
+
+	// The command run when none is given, split into words.
+	Default    string `yaml:"default"`
+	defaultCmd []string
+}
+
+// A repoOverride is settings of the repos matching any of its patterns.
+type repoOverride struct {
+	Match   []string          `yaml:"match"`   // Patterns, as of groups.
+	Env     map[string]string `yaml:"env"`     // Set in the environment of commands.
+	Command string            `yaml:"command"` // Run, split into words, instead of the default command.
+	Skip    bool              `yaml:"skip"`    // Never run in them.
+	Jobs    int               `yaml:"jobs"`    // If positive, run in at most this many of them at once.
+
+	patterns []repoPattern
+	command  []string
+	slots    chan struct{}
+}
+
+// defaultConfig returns the path of the config file read unless --config is
+// given.
+func defaultConfig() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "git-walk", "config.yaml")
+}
+
+// readConfig reads the config file at path, or if path is "", the default
+// one, if there is one.
+func readConfig(path string) (*config, error) {
+	given := path != ""
+	if !given {
+		path = defaultConfig()
+	}
+	cfg := &config{}
+	if path == "" {
+		return cfg, nil
+	}
+	data, err := os.ReadFile(expandHome(path))
+	if errors.Is(err, os.ErrNotExist) && !given {
+		return cfg, nil
+	} else if err != nil {
+		return nil, err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("bad config %s: %v", path, err)
+	}
+	cfg.path = path
+	for i, o := range cfg.Repos {
+		if err := o.compile(); err != nil {
+			return nil, fmt.Errorf("bad config %s: repo %d: %v", path, i+1, err)
+		}
+	}
+	cfg.aliases = map[string][]string{}
+	for name, command := range cfg.Aliases {
+		words, err := splitWords(command)
+		switch {
+		case err != nil:
+			return nil, fmt.Errorf("bad config %s: alias %q: %v", path, name, err)
+		case len(words) == 0:
+			return nil, fmt.Errorf("bad config %s: alias %q is empty", path, name)
+		case isCommand(name):
+			return nil, fmt.Errorf("bad config %s: alias %q is a command", path, name)
+		}
+		cfg.aliases[name] = words
+	}
+	if cfg.Default != "" {
+		words, err := splitWords(cfg.Default)
+		if err != nil {
+			return nil, fmt.Errorf("bad config %s: default: %v", path, err)
+		} else if len(words) == 0 {
+			return nil, fmt.Errorf("bad config %s: default is empty", path)
+		}
+		cfg.defaultCmd = words
+	}
+	return cfg, nil
+}
+
+// defaultCommand returns the command run when none is given: that of
+// $GIT_WALK_DEFAULT_CMD, if set, or of the config file, or git status.
+func (cfg *config) defaultCommand() ([]string, error) {
+	if env := os.Getenv("GIT_WALK_DEFAULT_CMD"); env != "" {
+		words, err := splitWords(env)
+		if err != nil {
+			return nil, fmt.Errorf("bad GIT_WALK_DEFAULT_CMD: %v", err)
+		} else if len(words) == 0 {
+			return nil, fmt.Errorf("bad GIT_WALK_DEFAULT_CMD: %q is empty", env)
+		}
+		return words, nil
+	}
+	if cfg.defaultCmd != nil {
+		return cfg.defaultCmd, nil
+	}
+	return gitVCS{}.status(), nil
+}
+
+func (o *repoOverride) compile() error {
+	if len(o.Match) == 0 {
+		return fmt.Errorf("no match patterns")
+	}
+	for _, p := range o.Match {
+		rp, err := compileRepoPattern(p)
+		if err != nil {
+			return err
+		}
+		o.patterns = append(o.patterns, rp)
+	}
+	if o.Command != "" {
+		words, err := splitWords(o.Command)
+		if err != nil {
+			return fmt.Errorf("bad command: %v", err)
+		}
+		o.command = words
+	}
+	if o.Jobs < 0 {
+		return fmt.Errorf("bad jobs: %d", o.Jobs)
+	} else if o.Jobs > 0 {
+		o.slots = make(chan struct{}, o.Jobs)
+	}
+	return nil
+}
+
+// overridesOf returns the overrides of the repo at dir, found in root, in
+// order.
+func (cfg *config) overridesOf(root, dir string) []*repoOverride {
+	var of []*repoOverride
+	for _, o := range cfg.Repos {
+		for _, p := range o.patterns {
+			if p.match(root, dir) {
+				of = append(of, o)
+				break
+			}
+		}
+	}
+	return of
+}
+
+// setsRuns reports whether the config sets how commands are run in any repos,
+// by their command, env, or jobs settings.
+func (cfg *config) setsRuns() bool {
+	for _, o := range cfg.Repos {
+		if o.command != nil || len(o.Env) > 0 || o.Jobs > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// skipFilter selects the repos the config doesn't say to skip.
+func (cfg *config) skipFilter(roots []string) filter {
+	return func(dir string) bool {
+		for _, o := range cfg.overridesOf(rootOf(roots, dir), dir) {
+			if o.Skip {
+				slog.Debug("skip", "repo", dir, "reason", "skipped by the config")
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// alias returns the command name is an alias of, if it is one.
+func (cfg *config) alias(name string) ([]string, bool) {
+	cmd, ok := cfg.aliases[name]
+	return cmd, ok
+}
+
+// groupNames returns the names of the groups, in order.
+func (cfg *config) groupNames() []string {
+	var names []string
+	for name := range cfg.Groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// A repoPattern matches the paths of repos, either relative to the root they
+// were found in, or if absolute, their absolute path.
+type repoPattern struct {
+	abs string // An absolute path, or a glob of them, or "".
+	rel pattern
+}
+
+func compileRepoPattern(p string) (repoPattern, error) {
+	if p == "~" || strings.HasPrefix(p, "~/") || filepath.IsAbs(p) {
+		p = filepath.Clean(expandHome(p))
+		if _, err := filepath.Match(p, ""); err != nil {
+			return repoPattern{}, fmt.Errorf("%q: %v", p, err)
+		}
+		return repoPattern{abs: p}, nil
+	}
+	rel, err := compilePattern(p)
+	return repoPattern{rel: rel}, err
+}
+
+// match reports whether the repo at dir, found in root, matches.
+func (p repoPattern) match(root, dir string) bool {
+	if p.abs != "" {
+		abs := absDir(dir)
+		ok, _ := filepath.Match(p.abs, abs)
+		return ok || abs == p.abs
+	}
+	rel, err := filepath.Rel(root, dir)
+	if err != nil {
+		rel = dir
+	}
+	return p.rel.match(filepath.ToSlash(rel))
+}
+
+// groupFilter selects repos in any of the groups named.
+func (cfg *config) groupFilter(roots []string, names []string) (filter, error) {
+	var ps []repoPattern
+	for _, name := range names {
+		patterns, ok := cfg.Groups[name]
+		if !ok {
+			if cfg.path == "" {
+				return nil, fmt.Errorf("no group %q, as there is no config file", name)
+			}
+			return nil, fmt.Errorf("no group %q in %s", name, cfg.path)
+		}
+		for _, p := range patterns {
+			rp, err := compileRepoPattern(p)
+			if err != nil {
+				return nil, fmt.Errorf("bad pattern in group %q: %v", name, err)
+			}
+			ps = append(ps, rp)
+		}
+	}
+	return func(dir string) bool {
+		root := rootOf(roots, dir)
+		for _, p := range ps {
+			if p.match(root, dir) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// What to do with a repo, once confirmed.
+type answer int
+
+const (
+	yes answer = iota
+	no
+	quit
+)
+
+// A confirmer asks before a command is run in each repo, or if once is true,
+// before the first.
+type confirmer struct {
+	once bool
+
+	lock sync.Mutex
+	in   *bufio.Reader
+	all  bool // Run in all repos without asking.
+	quit bool // Run in no more repos.
+}
+
+func newConfirmer(once bool) *confirmer {
+	// Stdin may be in use, by --stdin.
+	var in io.Reader = os.Stdin
+	if tty, err := os.Open(ttyIn); err == nil {
+		in = tty
+	}
+	return &confirmer{once: once, in: bufio.NewReader(in)}
+}
+
+// confirm asks whether to run rn in r.
+func (c *confirmer) confirm(rn *runner, r repo) answer {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.quit {
+		return quit
+	}
+	if c.all {
+		return yes
+	}
+
+	// Keep other output from interrupting the prompt.
+	output.Lock()
+	defer output.Unlock()
+
+	prompt := "[y]es, [n]o, [a]ll, [q]uit"
+	if c.once {
+		prompt = "[y]es, [n]o"
+	}
+	for {
+		if c.once {
+			fmt.Fprintf(os.Stderr, "%sRun in all repos? %s: ", rn.banner(r), prompt)
+		} else {
+			fmt.Fprintf(os.Stderr, "%sRun? %s: ", rn.banner(r), prompt)
+		}
+		line, err := c.in.ReadString('\n')
+		if err != nil {
+			fmt.Fprintln(os.Stderr)
+			c.quit = true
+			return quit
+		}
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "y", "yes":
+			c.all = c.once
+			return yes
+		case "n", "no":
+			if c.once {
+				c.quit = true
+				return quit
+			}
+			return no
+		case "a", "all":
+			if !c.once {
+				c.all = true
+				return yes
+			}
+		case "q", "quit":
+			if !c.once {
+				c.quit = true
+				return quit
+			}
+		}
+	}
+}
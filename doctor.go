@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	git "github.com/go-git/go-git/v5"
+)
+
+// gitStates are the files in a git dir that are there while an operation
+// is in progress, and what is in progress.
+var gitStates = []struct {
+	file, state string
+}{
+	{"rebase-merge", "rebasing"},
+	{"rebase-apply/applying", "applying patches"},
+	{"rebase-apply", "rebasing"},
+	{"MERGE_HEAD", "merging"},
+	{"CHERRY_PICK_HEAD", "cherry-picking"},
+	{"REVERT_HEAD", "reverting"},
+	{"BISECT_LOG", "bisecting"},
+}
+
+// doctorReasons returns why r is in a state that commands run in every repo,
+// like git pull, could make worse: its HEAD is detached, an operation is in
+// progress, there are unresolved conflicts, its branch has no upstream, or
+// it is a shallow clone.
+func doctorReasons(r repo) []string {
+	g, st := readHead(r)
+	if g == nil {
+		return []string{"error: " + st.err.Error()}
+	}
+	var reasons []string
+	gitDir := gitDirOf(r.dir)
+	if st.branch == "" {
+		reasons = append(reasons, "detached HEAD")
+	}
+	for _, s := range gitStates {
+		if _, err := os.Stat(filepath.Join(gitDir, s.file)); err == nil {
+			reasons = append(reasons, s.state)
+			break
+		}
+	}
+	if idx, err := g.Storer.Index(); err == nil {
+		conflicts := map[string]bool{}
+		for _, e := range idx.Entries {
+			if e.Stage != 0 {
+				conflicts[e.Name] = true
+			}
+		}
+		if len(conflicts) > 0 {
+			reasons = append(reasons, fmt.Sprintf("%d conflicted", len(conflicts)))
+		}
+	}
+	if st.branch != "" && !st.head.IsZero() {
+		_, bare := g.Worktree()
+		if _, ok := upstreamOf(g, st.branch); !ok && bare != git.ErrIsBareRepository {
+			reasons = append(reasons, "no upstream")
+		}
+	}
+	shared := commonDir(r.dir)
+	if shared == "" {
+		shared = gitDir
+	}
+	if _, err := os.Stat(filepath.Join(shared, "shallow")); err == nil {
+		reasons = append(reasons, "shallow")
+	}
+	return reasons
+}
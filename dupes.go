@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A clone is a repo, identified by the project it is a clone of.
+type clone struct {
+	repo    repo
+	project string // The normalized origin URL, or failing that, the root commit.
+	common  string // The git dir shared by the repo's linked worktrees.
+}
+
+// cloneOf identifies the project r is a clone of. Returns false if it can't
+// be identified, because it has neither remotes nor commits.
+func cloneOf(r repo) (clone, bool) {
+	c := clone{repo: r}
+	if url := originURL(r.dir); url != "" {
+		c.project = normalizeRemote(url)
+	} else if out, err := gitOutput(r.dir, "rev-list", "--max-parents=0", "HEAD"); err == nil && out != "" {
+		roots := strings.Fields(out)
+		sort.Strings(roots)
+		c.project = "root " + roots[0]
+	} else {
+		return c, false
+	}
+	common, err := gitOutput(r.dir, "rev-parse", "--git-common-dir")
+	if err == nil {
+		if !filepath.IsAbs(common) {
+			common = filepath.Join(r.dir, common)
+		}
+		c.common = filepath.Clean(common)
+	}
+	return c, true
+}
+
+// A dupeFinder collects clones, to find those of the same project.
+type dupeFinder struct {
+	lock   sync.Mutex
+	clones map[string][]clone // By project.
+}
+
+func (d *dupeFinder) add(c clone) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.clones == nil {
+		d.clones = map[string][]clone{}
+	}
+	// Linked worktrees share their repo, so aren't duplicates of it.
+	for _, o := range d.clones[c.project] {
+		if c.common != "" && o.common == c.common {
+			return
+		}
+	}
+	d.clones[c.project] = append(d.clones[c.project], c)
+}
+
+// write writes each project with more than one clone, and the clones with
+// their sizes on disk, naming repos with name.
+func (d *dupeFinder) write(w io.Writer, name func(repo) string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	var projects []string
+	for p, clones := range d.clones {
+		if len(clones) > 1 {
+			projects = append(projects, p)
+		}
+	}
+	sort.Strings(projects)
+	for _, p := range projects {
+		clones := d.clones[p]
+		sort.Slice(clones, func(i, j int) bool {
+			return clones[i].repo.dir < clones[j].repo.dir
+		})
+		fmt.Fprintf(w, "%s (%d clones)\n", p, len(clones))
+		for _, c := range clones {
+			fmt.Fprintf(w, "    %6s  %s\n", humanSize(diskUsage(c.repo.dir)), name(c.repo))
+		}
+	}
+}
+
+// diskUsage returns the total size of the files in dir, and below it.
+func diskUsage(dir string) int64 {
+	var total int64
+	filepath.WalkDir(dir, func(path string, e fs.DirEntry, err error) error {
+		if err == nil && e.Type().IsRegular() {
+			if info, err := e.Info(); err == nil {
+				total += info.Size()
+			}
+		}
+		return nil
+	})
+	return total
+}
+
+// humanSize describes a size in bytes briefly, like du -h.
+func humanSize(n int64) string {
+	const units = "KMGTPE"
+	if n < 1024 {
+		return fmt.Sprintf("%dB", n)
+	}
+	size := float64(n)
+	i := -1
+	for size >= 1024 && i < len(units)-1 {
+		size /= 1024
+		i++
+	}
+	if size < 10 {
+		return fmt.Sprintf("%.1f%c", size, units[i])
+	}
+	return fmt.Sprintf("%.0f%c", size, units[i])
+}
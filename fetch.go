@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// fetchRepo fetches every remote of r with go-git, instead of running git,
+// writing the remote branches that changed to stdout, and the remotes that
+// failed to stderr. Over HTTP, connections to a host are kept alive and shared
+// between repos. Over SSH, keys are taken from the ssh-agent.
+func fetchRepo(ctx context.Context, r repo, stdout, stderr io.Writer) error {
+	g, err := openRepo(r.dir)
+	if err != nil {
+		return err
+	}
+	remotes, err := g.Remotes()
+	if err != nil {
+		return err
+	}
+	var failure error
+	for _, remote := range remotes {
+		name := remote.Config().Name
+		before := remoteRefs(g, name)
+		err := remote.FetchContext(ctx, &git.FetchOptions{
+			RemoteName: name,
+			Prune:      true,
+		})
+		if err != nil && err != git.NoErrAlreadyUpToDate {
+			fmt.Fprintf(stderr, "fetch %s failed with %v\n", name, err)
+			failure = err
+			continue
+		}
+		after := remoteRefs(g, name)
+		for _, ref := range changedRefs(before, after) {
+			was, now := before[ref], after[ref]
+			switch {
+			case was.IsZero():
+				fmt.Fprintf(stdout, " * [new] %s\n", ref)
+			case now.IsZero():
+				fmt.Fprintf(stdout, " - [deleted] %s\n", ref)
+			default:
+				fmt.Fprintf(stdout, "   %s..%s %s\n", was.String()[:7], now.String()[:7], ref)
+			}
+		}
+	}
+	return failure
+}
+
+// remoteRefs returns the hashes of the remote's branches, by short name.
+func remoteRefs(g *git.Repository, remote string) map[string]plumbing.Hash {
+	refs := map[string]plumbing.Hash{}
+	iter, err := g.References()
+	if err != nil {
+		return refs
+	}
+	iter.ForEach(func(ref *plumbing.Reference) error {
+		n := ref.Name()
+		if n.IsRemote() && ref.Type() == plumbing.HashReference &&
+			strings.HasPrefix(n.Short(), remote+"/") {
+			refs[n.Short()] = ref.Hash()
+		}
+		return nil
+	})
+	return refs
+}
+
+// changedRefs returns the names of the refs that differ between before and
+// after, sorted.
+func changedRefs(before, after map[string]plumbing.Hash) []string {
+	var changed []string
+	for ref, h := range before {
+		if after[ref] != h {
+			changed = append(changed, ref)
+		}
+	}
+	for ref := range after {
+		if _, ok := before[ref]; !ok {
+			changed = append(changed, ref)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
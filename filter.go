@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A filter decides whether the command should be run in the repo at dir.
+type filter func(dir string) bool
+
+// selected reports whether dir passes every filter.
+func selected(filters []filter, dir string) bool {
+	for _, f := range filters {
+		if !f(dir) {
+			return false
+		}
+	}
+	return true
+}
+
+// pattern is a path pattern, either a glob, or a regexp if written as
+// `re:<regexp>`.
+type pattern struct {
+	glob string
+	re   *regexp.Regexp
+}
+
+func compilePattern(p string) (pattern, error) {
+	if strings.HasPrefix(p, "re:") {
+		re, err := regexp.Compile(p[3:])
+		if err != nil {
+			return pattern{}, err
+		}
+		return pattern{re: re}, nil
+	}
+	if _, err := filepath.Match(p, ""); err != nil {
+		return pattern{}, fmt.Errorf("%q: %v", p, err)
+	}
+	return pattern{glob: p}, nil
+}
+
+// match reports whether rel, a slash-separated path, matches. Globs without
+// a slash are matched against the last path element, like .gitignore.
+func (p pattern) match(rel string) bool {
+	if p.re != nil {
+		return p.re.MatchString(rel)
+	}
+	name := rel
+	if !strings.Contains(p.glob, "/") {
+		name = filepath.Base(rel)
+	}
+	ok, _ := filepath.Match(p.glob, name)
+	return ok
+}
+
+// matchFilter selects repos whose path relative to the root they were found
+// in matches any of patterns.
+func matchFilter(roots []string, patterns []string) (filter, error) {
+	var ps []pattern
+	for _, p := range patterns {
+		cp, err := compilePattern(p)
+		if err != nil {
+			return nil, fmt.Errorf("bad --match pattern: %v", err)
+		}
+		ps = append(ps, cp)
+	}
+	return func(dir string) bool {
+		rel, err := filepath.Rel(rootOf(roots, dir), dir)
+		if err != nil {
+			rel = dir
+		}
+		rel = filepath.ToSlash(rel)
+		for _, p := range ps {
+			if p.match(rel) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// dirtyFilter selects repos with staged, unstaged, or untracked changes.
+func dirtyFilter(dir string) bool {
+	out, err := gitOutput(dir, "status", "--porcelain")
+	if err != nil {
+		slog.Debug("status failed", "repo", dir, "err", err)
+		return false
+	}
+	return out != ""
+}
+
+// stashedFilter selects repos with stashes.
+func stashedFilter(dir string) bool {
+	var st repoStatus
+	readStashes(repo{dir: dir}, &st)
+	return st.stashes > 0
+}
+
+// walkSkipFilter selects the repos whose git config doesn't set walk.skip.
+func walkSkipFilter(dir string) bool {
+	shared := commonDir(dir)
+	if shared == "" {
+		shared = gitDirOf(dir)
+	}
+	// Only ask git, which is slow to, of the repos that might set it.
+	data, err := os.ReadFile(filepath.Join(shared, "config"))
+	if err != nil || !bytes.Contains(bytes.ToLower(data), []byte("[walk")) {
+		return true
+	}
+	skip, _ := gitOutput(dir, "config", "--local", "--bool", "--get", "walk.skip")
+	return skip != "true"
+}
+
+// branchFilter selects repos whose current branch matches any of globs, or,
+// if not is true, matches none of them. A detached HEAD matches nothing.
+func branchFilter(globs []string, not bool) (filter, error) {
+	for _, g := range globs {
+		if _, err := path.Match(g, ""); err != nil {
+			return nil, fmt.Errorf("bad branch pattern %q: %v", g, err)
+		}
+	}
+	return func(dir string) bool {
+		branch := currentBranch(dir)
+		for _, g := range globs {
+			if ok, _ := path.Match(g, branch); ok && branch != "" {
+				return !not
+			}
+		}
+		return not
+	}, nil
+}
+
+// remoteFilter selects repos with a remote whose URL, as written or
+// normalized to host/path form, matches any of patterns.
+func remoteFilter(patterns []string) (filter, error) {
+	var ps []pattern
+	for _, p := range patterns {
+		cp, err := compilePattern(p)
+		if err != nil {
+			return nil, fmt.Errorf("bad --remote-match pattern: %v", err)
+		}
+		ps = append(ps, cp)
+	}
+	return func(dir string) bool {
+		for _, url := range remoteURLs(dir) {
+			for _, p := range ps {
+				if p.match(url) || p.match(normalizeRemote(url)) {
+					return true
+				}
+			}
+		}
+		return false
+	}, nil
+}
+
+// hasFileFilter selects repos whose working tree has a file matching any of
+// globs, each relative to its top, such as go.mod, or cmd/*/main.go.
+func hasFileFilter(globs []string) (filter, error) {
+	for _, g := range globs {
+		if _, err := filepath.Match(g, ""); err != nil {
+			return nil, fmt.Errorf("bad --has-file pattern %q: %v", g, err)
+		}
+	}
+	return func(dir string) bool {
+		for _, g := range globs {
+			if matches, _ := filepath.Glob(filepath.Join(dir, filepath.FromSlash(g))); len(matches) > 0 {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// trackingFilter selects repos whose branch is only ahead of its upstream,
+// only behind it, or diverged from it, as wanted.
+func trackingFilter(ahead, behind, diverged bool) filter {
+	return func(dir string) bool {
+		g, st := readHead(repo{dir: dir})
+		if g == nil {
+			slog.Debug("read failed", "repo", dir, "err", st.err)
+			return false
+		}
+		readUpstream(g, &st)
+		switch {
+		case st.ahead > 0 && st.behind > 0:
+			return diverged
+		case st.ahead > 0:
+			return ahead
+		case st.behind > 0:
+			return behind
+		}
+		return false
+	}
+}
+
+// ifFilter selects repos where the predicate command succeeds. Its output is
+// only logged.
+func ifFilter(predicate string) (filter, error) {
+	cmd, err := splitWords(predicate)
+	if err != nil {
+		return nil, fmt.Errorf("bad --if command: %v", err)
+	}
+	if len(cmd) == 0 {
+		return nil, fmt.Errorf("bad --if command: %q is empty", predicate)
+	}
+	return func(dir string) bool {
+		pred := exec.Command(cmd[0], cmd[1:]...)
+		pred.Dir = dir
+		out, err := pred.CombinedOutput()
+		slog.Debug("if", "repo", dir, "err", err, "output", string(out))
+		return err == nil
+	}, nil
+}
+
+// parseAge parses an age, which is a duration as understood by
+// time.ParseDuration, or a number of days, weeks, or years, like 90d, 2w, or
+// 1y.
+func parseAge(s string) (time.Duration, error) {
+	units := map[string]time.Duration{
+		"d": 24 * time.Hour,
+		"w": 7 * 24 * time.Hour,
+		"y": 365 * 24 * time.Hour,
+	}
+	if len(s) > 1 {
+		if unit, ok := units[s[len(s)-1:]]; ok {
+			n, err := strconv.ParseFloat(s[:len(s)-1], 64)
+			if err != nil || n < 0 {
+				return 0, fmt.Errorf("bad age %q", s)
+			}
+			return time.Duration(n * float64(unit)), nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("bad age %q", s)
+	}
+	return d, nil
+}
+
+// ageFilter selects repos whose last activity was longer ago than age, or if
+// newer is true, more recently. Repos without any activity are older than
+// every age.
+func ageFilter(age time.Duration, newer bool) filter {
+	return func(dir string) bool {
+		last := lastActivity(dir)
+		slog.Debug("last activity", "repo", dir, "time", last)
+		recent := !last.IsZero() && time.Since(last) < age
+		return recent == newer
+	}
+}
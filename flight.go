@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A flight tracks the repos found, and those being run in, and since when, to
+// write how the run is going when git-walk is asked, by signal.
+type flight struct {
+	results *tally
+
+	lock     sync.Mutex
+	repos    []repo
+	allFound bool
+	running  map[int]time.Time // When running in each repo began, by seq.
+}
+
+func newFlight(results *tally) *flight {
+	return &flight{results: results, running: map[int]time.Time{}}
+}
+
+// add records that r was found.
+func (f *flight) add(r repo) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.repos = append(f.repos, r)
+}
+
+// found records that all the repos have been found.
+func (f *flight) found() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.allFound = true
+}
+
+// started records that r is being run in.
+func (f *flight) started(r repo) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.running[r.seq] = time.Now()
+}
+
+// stopped records that r is no longer being run in.
+func (f *flight) stopped(r repo) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	delete(f.running, r.seq)
+}
+
+// write writes which repos are being run in, and for how long, how many are
+// waiting to be, and how running in the rest went.
+func (f *flight) write(w io.Writer, path func(repo) string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	var running []repo
+	count := map[status]int{}
+	for _, r := range f.repos {
+		if _, ok := f.running[r.seq]; ok {
+			running = append(running, r)
+		} else {
+			count[f.results.statusOf(r)]++
+		}
+	}
+	sort.Slice(running, func(i, j int) bool {
+		return f.running[running[i].seq].Before(f.running[running[j].seq])
+	})
+	found := fmt.Sprintf("%d found", len(f.repos))
+	if !f.allFound {
+		found += " so far"
+	}
+	fmt.Fprintf(w, "%d running, %d waiting, %d succeeded, %d failed, %d skipped, of %s\n",
+		len(running), count[pending], count[succeeded], count[failed], count[skipped], found)
+	for _, r := range running {
+		fmt.Fprintf(w, "  %s: running for %v\n", path(r), time.Since(f.running[r.seq]).Round(time.Second))
+	}
+}
+
+// notify writes how the run is going to stderr whenever git-walk receives one
+// of the dumpSignals, until stopped.
+func (f *flight) notify(path func(repo) string) (stop func()) {
+	if len(dumpSignals) == 0 {
+		return func() {}
+	}
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, dumpSignals...)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-c:
+				output.Lock()
+				clearProgress()
+				f.write(os.Stderr, path)
+				output.Unlock()
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(c)
+		close(done)
+	}
+}
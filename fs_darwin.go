@@ -0,0 +1,22 @@
+package main
+
+import "syscall"
+
+// fsTypesKnown is whether the types of filesystems can be told.
+const fsTypesKnown = true
+
+// fsTypeOf returns the type of the filesystem that path is on, if known.
+func fsTypeOf(path string) (string, bool) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return "", false
+	}
+	var name []byte
+	for _, c := range st.Fstypename {
+		if c == 0 {
+			break
+		}
+		name = append(name, byte(c))
+	}
+	return string(name), len(name) > 0
+}
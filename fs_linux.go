@@ -0,0 +1,42 @@
+package main
+
+import "syscall"
+
+// fsTypes names the filesystems of statfs(2), by their magic numbers.
+var fsTypes = map[uint32]string{
+	0x6969:     "nfs",
+	0xff534d42: "cifs",
+	0xfe534d42: "smb2",
+	0x517b:     "smb",
+	0x65735546: "fuse",
+	0x01021997: "9p",
+	0x5346414f: "afs",
+	0x00c36400: "ceph",
+	0x0bd00bd0: "lustre",
+	0x47504653: "gpfs",
+	0x19830326: "beegfs",
+	0x564c:     "ncp",
+	0x73757245: "coda",
+	0x0187:     "autofs",
+	0x01021994: "tmpfs",
+	0x794c7630: "overlay",
+	0x9fa0:     "proc",
+	0x62656572: "sysfs",
+	0xef53:     "ext4",
+	0x9123683e: "btrfs",
+	0x58465342: "xfs",
+	0x2fc12fc1: "zfs",
+}
+
+// fsTypesKnown is whether the types of filesystems can be told.
+const fsTypesKnown = true
+
+// fsTypeOf returns the type of the filesystem that path is on, if known.
+func fsTypeOf(path string) (string, bool) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return "", false
+	}
+	name, ok := fsTypes[uint32(st.Type)]
+	return name, ok
+}
@@ -0,0 +1,12 @@
+//go:build !linux && !darwin
+
+package main
+
+// fsTypesKnown is whether the types of filesystems can be told.
+const fsTypesKnown = false
+
+// fsTypeOf returns the type of the filesystem that path is on, which isn't
+// known here.
+func fsTypeOf(path string) (string, bool) {
+	return "", false
+}
@@ -1,181 +1,2251 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
+	"log/slog"
+	"net/http"
 	"os"
-	"os/exec"
-	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
-	"syscall"
+	"text/template"
+	"time"
 
 	getopt "github.com/pborman/getopt/v2"
 )
 
 const HELP = `
-Run ` + "`command`" + ` in every git repo found. The command defaults to:
+Commands:
+
+    exec      Run a command in every git repo found (the default)
+    list      List the git repos found
+    status    Print a table of each repo's branch and state
+    fetch     Fetch every remote of every repo, in-process
+    maintenance
+              Run git maintenance, or gc, in every repo not maintained lately
+    dirty     List the repos needing a commit or push, or with stashes, and why
+    doctor    List the repos in states a sweeping git pull could make worse,
+              like with a detached HEAD, or a rebase in progress, and why
+    branches  List the branch checked out in each repo
+    dupes     List the repos that are clones of the same project
+    audit     List the large files, and likely secrets, in every repo
+    grep      Search every repo with git grep, writing REPO:PATH:LINE:TEXT
+    manifest  Export a manifest of the repos found (manifest export)
+    sync      Clone and fast-forward the repos in a manifest
+    clone     Clone the missing repos of an organization, then exec
+    serve     Serve the repos found, their status, and running in them, over HTTP
+    history   List past runs (history), one (history show ID), or the repos
+              failing in one, but not another (history diff ID ID)
+    completion
+              Print a completion script for bash, zsh, or fish, as in
+              source <(git-walk completion bash)
+
+Options given before the command are shared by every command, and are for
+finding repos, selecting them, and running in them. Options given after the
+command are particular to it, see git-walk COMMAND --help. The options in
+$GIT_WALK_OPTS, split into words as a shell would, are given before those on
+the command line, so they can be overridden by them.
+
+Installed on the PATH, git-walk is run as git walk, too, and reads defaults
+from the walk.* git config, which $GIT_WALK_OPTS, and the command line,
+override: walk.where, where to look for repos without --where, given once for
+each, walk.concurrency, the default of -n, and walk.exclude, more directory
+names, like --default-excludes, not to look in. Repos whose own git config
+sets walk.skip to true, as with git config walk.skip true, are never run in.
+
+If no command is given, the arguments are run in every repo, as with exec, and
+the command run defaults to:
 
     git status --short -b
 
+unless another is given by $GIT_WALK_DEFAULT_CMD, or default in the config
+file, split into words as a shell would, as in default: git status --porcelain.
+
 By default, the commands are run in parallel, and their stderr and stdout are
 printed when the commmand completes, to avoid having the parallel command
 output intermingled unintelligibly. Some commands only colorize when writing to
 a terminal, in which case --serial may be useful, which runs the command with
-output directly to the console at the price of being slower.
+output directly to the console at the price of being slower. For long running
+commands, --stream prints output as soon as it is written, with every line
+prefixed by the repo it came from.
+
+With -n auto, as many commands are run at once as there are CPUs, or 32 of
+fetch, clone, sync, or git commands that talk to remotes, halving that each
+time one fails with what looks like a rate limit error, or 4 of maintenance,
+which is heavy on IO, as it is also without -n.
+
+Repos are looked for in --walkers directories at once, so they are found in no
+particular order. Those listed, or run in with --serial, are found in the order
+of their paths once each --where has been looked in, and with --ordered, output
+is written in that order.
 
 Examples:
 
     git-walk -p -q -- git describe
-    git-walk -- git fetch --prune --all
+    git-walk fetch
     git-walk -- git co master
+    git-walk --match 'work/*-service' exec -- git pull
+    git-walk --branch 'release/*' exec -- git pull
+    git-walk list -0 | xargs -0 du -sh
+    git-walk --dirty list | grep service | git-walk --stdin -- git diff --stat
+    git-walk -- tar czf /backups/{name}.tgz {path}
+    git-walk exec -c 'git fetch && git status -sb'
+    git-walk exec -x 'git fetch' -x 'git rebase origin/main'
+    git-walk --match 'work/*' status
+
+Patterns given to --match are globs, or regexps if written as re:REGEXP. A glob
+without a slash matches the repo's directory name, otherwise it matches the
+repo's path relative to --where. Remote URLs are matched both as written and
+normalized to HOST/PATH form, without any user, port, or .git suffix, so
+--remote-match 'github.com/myorg/*' matches git@github.com:myorg/repo.git.
+
+The placeholders {path}, {name}, {branch}, and {remote} in the command are
+replaced with the repo's absolute path, directory name, current branch, and
+origin URL. In the script of a shell, as run by --shell, they are quoted, so
+each is one word, and needs no quotes of its own. With --here, commands are run
+where git-walk was run, rather than in the repo, with {} replaced by its path,
+as it was found, as in git-walk --here exec -- backup-tool --repo {}. Commands
+are run with GIT_WALK_DIR, GIT_WALK_NAME, GIT_WALK_ROOT, and GIT_WALK_INDEX set
+in their environment for the repo they are run in, and with GIT_WALK_TOTAL set
+if all repos had been found when they started.
+
+Commands run one at a time, with -1, read git-walk's stdin, unless the repos
+are read from it. Otherwise they are given no stdin, unless given --stdin-each,
+to read git-walk's once, and give each command all of it.
+
+With --batch N, exec runs its command once for each N repos, as xargs does,
+where git-walk was run, with their paths after its arguments, or in place of
+an argument that is {}, as in git-walk --batch 50 exec du -s. Each repo in a
+batch went as the command did, and its output is written as if of the first.
+Batches aren't retried, nor limited --per-host, and the command, env, and jobs
+settings of repos in the config file can't be used with them.
+
+The status command reads each repo directly, without running git, and prints a
+table of its branch, whether it is clean or dirty, how far ahead and behind its
+upstream it is, and how long ago it was last committed to.
+
+The clone command lists the repos of a GitHub organization, GitLab group and its
+subgroups, or Bitbucket workspace, clones those missing from --where into
+ORG/NAME, and then runs its command in every repo, as exec does. GitHub is
+asked with $GITHUB_TOKEN, at $GITHUB_API_URL if set, GitLab with $GITLAB_TOKEN,
+and Bitbucket with $BITBUCKET_TOKEN.
+
+With --from, it clones the repos whose URLs are listed in a file, one a line,
+into HOST/ORG/NAME, as in git-walk clone --from urls.txt --into ~/src, to set
+up a new machine. Clones run in parallel, as many at once as commands are, and
+with --per-host, at most that many from the same host, and those that fail are
+listed in the --summary.
+
+The maintenance command runs git maintenance run --auto, or with --gc, git gc
+--auto, in each repo not maintained in the last day, or --since, and writes how
+much space that reclaimed in each, and in all.
+
+The grep command runs git grep in each repo, searching the files it tracks, and
+writes each match as REPO:PATH:LINE:TEXT, or with --json, as a line of JSON,
+as in git-walk grep -w TODO -- '*.go'.
+
+The audit command reads the files committed at HEAD in each repo, or with
+--history, in every commit, and lists those larger than --max-size, and the
+lines that look like secrets, such as AWS, GitHub, or Slack keys and tokens, or
+private keys, without writing the secrets. Repos with findings fail.
+
+Directories with a .nogitwalk file, or an empty .gitwalkignore file, are not
+looked in for repos. A .gitwalkignore with patterns, written as in .gitignore,
+excludes the paths below its directory that they match.
+
+Directories named as in --default-excludes, like node_modules, vendor, or
+target, aren't looked in either, unless given --no-default-excludes. To change
+which are, set --default-excludes in $GIT_WALK_OPTS.
+
+With --one-file-system, other filesystems mounted below --where aren't looked
+in, and with --skip-fs, nor are those of the types given, such as nfs, cifs,
+smb2, fuse, or 9p, matching those whose types start with them.
+
+Without --where, repos are looked for where walk.where says, or in the ghq
+root, if $GHQ_ROOT or the ghq.root git config is set, and otherwise in the
+current directory. Repos are named by their path relative to where they were
+looked for, so repos in the ghq root are named like HOST/ORG/REPO. A ~ at the
+start of --where is its home directory, and globs in it, like
+~/src/*/services, are each of the directories they match, as they would be if
+the shell expanded them.
+
+Only git repos are found, unless given --vcs, listing the VCSs whose repos are
+found: git, hg (Mercurial, repos with .hg), svn (Subversion working copies,
+with .svn), or jj (Jujutsu, with .jj), a repo being of the first listed that it
+is one of. Without a command, the status command of each repo's VCS is run, and
+commands find its name in $GIT_WALK_VCS. The other commands, but for list and
+clone, only run in git repos.
+
+Linked worktrees share their repo's objects and refs with its main worktree,
+so commands like git fetch needn't be run in each. With --worktrees
+skip-linked, linked worktrees aren't run in, and with --worktrees primary-only,
+the main worktree of each repo is run in, once, in place of its linked ones,
+even if it isn't in --where.
+
+Given --host, repos are looked for on each host, over ssh, by the git-walk on
+its PATH, or with --ship, a copy of this one, in --where, or the home directory
+there, with the options given for finding and selecting repos. The commands
+are then run in them over ssh, and the repos are named by the host, and their
+path there, as in build1:src/app. Only exec and list can be used with --host.
+
+Given --where more than once, repos are looked for in each, in turn, and run in
+and summarized together. Repos in more than one, because they overlap, are only
+run in once, and named relative to the deepest. The clone command clones into
+the first, and sync can only be used with one.
+
+The config file, git-walk/config.yaml in the user's config directory, or that
+given by --config, is YAML, and can name groups of repos, to select with
+--group, each a list of patterns matched as --match patterns are, or paths of
+repos, or globs of them, when they begin with / or ~/, as in:
+
+    groups:
+      work: [work/*, ~/src/infra]
+      oss: [oss/*]
+
+It can also name aliases, commands run in every repo when given as the command,
+as exec would run them, with any arguments after them, as in:
+
+    aliases:
+      pull: git pull --ff-only --prune
+      up: git fetch --all --prune
+
+It can also give settings of the repos matching any of a list of patterns, as
+groups are, applied in order, the later overriding the earlier: env, variables
+set in the environment of commands, command, run in place of the default
+command, skip, to never run in them, and jobs, to run in at most that many of
+them at once, as in:
+
+    repos:
+      - match: [infra/*]
+        env: {GIT_SSH_COMMAND: ssh -i ~/.ssh/infra}
+      - match: [big/monorepo, "*-mirror"]
+        command: git fetch --prune
+        jobs: 1
+      - match: [vendor/*]
+        skip: true
+
+Repos are written in the lines about running in them, and in summaries, by
+their path, and elsewhere, as in --stream prefixes, tables, and reports, by
+their name. Given --path-format, they are written everywhere by their path
+relative to where they were found (rel), their absolute path (abs), or their
+directory name (name).
+
+With --header, the lines about each command run are replaced by a Go template
+written before each repo's output, and with --footer, one is written after it,
+as in --header '== {{.Name}} ({{.Branch}}) =='. They can use the repo's .Name,
+.Path, .Dir, .Root, .Branch, and .Remote, the .Command run, its .Index and the
+.Total found, and in the footer, the .Code it exited with, the .Duration it
+took, and its .Status.
+
+With --pick, once all repos are found, those the filters select are listed to
+be chosen from. Type to narrow the list to the repos whose names contain those
+letters in order, tab to choose a repo, ^A to choose all those listed, and
+enter to run in those chosen, or in the one under the cursor if none were.
+
+With --watch, once the command has run in every repo, it is run again in each
+repo whenever the files in its working tree that git doesn't ignore, or its
+HEAD, index, or refs change, once they have stopped changing for --watch-delay.
+This goes on until git-walk is interrupted, or the --deadline is exceeded.
+
+With --metrics, while watching, and always when serving, Prometheus metrics are
+served at /metrics: gauges of the repos found, and of those dirty, ahead of,
+behind, or diverged from their upstream, as last read, a counter of the
+commands run, by how they finished, and a histogram of how long each took.
+
+The serve command looks for repos, and again every --rescan, and serves JSON
+over HTTP: GET /repos lists the repos found, POST /rescan looks for them again
+first, GET /status reads the status of each repo selected, as the status command
+does, and with --allow-run, POST /run runs the command given in a body like
+{"command": ["git", "pull"]}, or {"shell": "make"}, sent as application/json, in
+each repo selected, and in those matching its optional "match" patterns,
+streaming a line of JSON with the result of each as it finishes, and then one
+summarizing them all. Requests sent by the pages of other sites, or addressed
+to other hosts than localhost, or the --listen address, are refused, and with
+--token-file, so are those without the token in the file, as in Authorization:
+Bearer TOKEN, which --allow-run requires.
+
+Reports given with --report are written once done, with a row for each repo,
+and how running in it went. The formats are:
+
+    junit     JUnit XML, with a test case for each repo, and its output,
+              without banners, failing with the exit code and stderr of those
+              that failed
+    tap       TAP, with a test point for each repo, and its exit code and
+              output as YAML diagnostics
+    markdown  A Markdown table of each repo, its branch, status, and the first
+              line of its output, or of its errors if it failed
+    csv, tsv  A header, and a line for each repo, of the --fields: repo, path,
+              branch, status, exit (code), duration (in seconds), and output
+              (its first line, as in markdown)
+    html      A page with a table of the repos, that can be sorted by any
+              column, and the output of each, that can be expanded
+
+A report can also be printed with --format, in place of each repo's output.
+
+Hooks are shell scripts run around the commands. --before-each is run in each
+repo first, and if it fails, the commands aren't run, and the repo fails.
+--after-each is run in each repo after them, told how running went in
+$GIT_WALK_STATUS and $GIT_WALK_EXIT. --before-all is run where git-walk was, in
+the paths of --where listed in $GIT_WALK_WHERE, before running in any repo, and
+if it fails, nothing is run. --after-all is run there once done, told how many
+repos there were, and how many succeeded, failed, and were skipped, in
+$GIT_WALK_TOTAL, $GIT_WALK_SUCCEEDED, $GIT_WALK_FAILED, and $GIT_WALK_SKIPPED,
+and how the run went, succeeded, failed, or interrupted, in $GIT_WALK_STATUS.
+
+With --job-log, a line of JSON is appended to the log as running in each repo
+starts, and as it ends, with the repo, the command, and how it went. Once a run
+has been interrupted, or has failed in some repos, it can be picked up again
+with --resume, skipping the repos the log records as having succeeded, or with
+--retry-failed, running only in those it records as having failed, or not
+finished. Either goes on appending to the log.
+
+Only one git-walk at a time runs commands in the repos of each --where, taking
+a lock in the user's cache directory to do so. Another fails, unless given
+--wait, to wait for the lock, or --no-lock, to run anyway.
+
+Each run of commands is saved in the history, in the user's cache directory,
+unless given --no-history, with the commands run, and how running in each repo
+went. The history command lists the last runs, shows one, or compares the repos
+failing in two, and keeps the last 100.
+
+With --stats-json, metrics of the run are written as it exits, for tools that
+collect them: how many repos were found, and run in, and how each went, how
+long looking for them, and running in them, took, how many were run in at
+once, and how many bytes of output the commands wrote.
+
+With --results-dir, a file of JSON is written for each repo run in, as
+REPO.json, with the commands run, how they went, their exit code, when they
+started, how long they took, and the branch, and the paths of their output,
+written to REPO.stdout and REPO.stderr, for automation to read. Once done,
+index.json lists each repo found, how running in it went, and where its
+result is, with the metrics of the run, as --stats-json writes them.
+
+Errors looking for repos, like directories that can't be read, are each
+written, and counted in the --summary. With --walk-errors ignore, they are only
+counted, and with --walk-errors fail, the first stops the run.
+
+Warnings, like output that couldn't be spooled to a file, are logged to stderr.
+With --log-level info, so is how the run was set up, and with debug, or -d,
+why each directory wasn't looked in, and each repo was skipped, and when each
+command starts waiting, starts, and exits. With --log-format json, each event
+is logged as a line of JSON, rather than of key=value text.
+
+Commands run in the background, rather than directly on the terminal, that ask
+for passwords, passphrases, and the like, ask through git-walk, as their
+$GIT_ASKPASS and $SSH_ASKPASS, unless already set. ssh, which can ask on the
+terminal itself, is only made to ask through git-walk when commands run in
+parallel, and $SSH_ASKPASS_REQUIRE isn't set. Each question is asked on the
+terminal, one at a time, named by the repo, while other output waits. With
+--no-prompts, they aren't asked, and git fails instead.
+
+Commands that time out, or are running when git-walk is interrupted, are asked
+to exit, along with the processes they started, like ssh, and are killed if
+they haven't within 3 seconds, or at once, if git-walk is interrupted again.
+
+Sent SIGUSR1 while running, git-walk writes how the run is going to stderr:
+which repos are being run in, and for how long, how many are waiting, and how
+many have succeeded, failed, or been skipped, like so:
+
+  kill -USR1 $(pgrep -x git-walk)
+
+Exit status is 0 on success, 1 if commands failed (see --exit-code), 2 if there
+were errors looking for repos, other than those ignored, 3 if no repos were
+found, unless given --allow-empty, and 124 if the --deadline was exceeded.
 `
 
 // XXX use pty to support colorization in parallel?
 // - https://github.com/creack/pty
 
-// Serialize writing of multi-line output so it is not interleaved.
-var output sync.Mutex
-
 func cwd() string {
 	wd, _ := os.Getwd()
 	return wd
 }
 
+// Exit statuses, other than success.
+const (
+	exitFailed   = 1   // Commands failed, as decided by --exit-code.
+	exitWalk     = 2   // Errors looking for repos.
+	exitEmpty    = 3   // No repos were found, without --allow-empty.
+	exitDeadline = 124 // The --deadline was exceeded, the same as timeout(1).
+)
+
+// exitStatus returns the exit status of a run that completed, given the
+// --exit-code policy. Command failures take precedence over walk errors.
+func exitStatus(policy string, results *tally, repos []repo, walkErrors int) int {
+	bad := len(results.with(repos, failed))
+	ran := bad + len(results.with(repos, succeeded))
+	switch {
+	case policy == "any" && bad > 0:
+		return exitFailed
+	case policy == "all" && bad > 0 && bad == ran:
+		return exitFailed
+	case walkErrors > 0:
+		return exitWalk
+	}
+	return 0
+}
+
+// die reports a usage error and exits.
+func die(err error) {
+	fmt.Fprintf(os.Stderr, "%v\n", err)
+	os.Exit(1)
+}
+
+// label describes where a repo came from, as a suffix for its banner.
+func label(r repo) string {
+	if r.super == "" {
+		return ""
+	}
+	return "  # submodule of " + r.super
+}
+
+// stringList is a repeatable string option. Unlike getopt's []string, values
+// are not split on commas.
+type stringList []string
+
+func (l *stringList) Set(value string, opt getopt.Option) error {
+	*l = append(*l, value)
+	return nil
+}
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+// commands are the names of the commands that can be run.
+var commands = []string{"exec", "list", "status", "fetch", "maintenance", "dirty", "doctor", "branches", "dupes", "audit", "grep", "manifest", "sync", "clone", "serve", "history", "completion"}
+
+func isCommand(arg string) bool {
+	for _, c := range commands {
+		if arg == c {
+			return true
+		}
+	}
+	return false
+}
+
+// args0 returns the first of args, or "" if there are none.
+func args0(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	return args[0]
+}
+
+// afterDashes reports whether args, the arguments left after parsing the
+// options of argv, followed a "--", so the first is not a command name.
+func afterDashes(argv, args []string) bool {
+	n := len(argv) - len(args)
+	return n > 0 && argv[n-1] == "--"
+}
+
+// defaultOptions returns the options in $GIT_WALK_OPTS, to be given before
+// those on the command line.
+func defaultOptions() ([]string, error) {
+	opts, err := splitWords(os.Getenv("GIT_WALK_OPTS"))
+	if err != nil {
+		return nil, fmt.Errorf("bad GIT_WALK_OPTS: %v", err)
+	}
+	for _, opt := range opts {
+		if opt == "--" {
+			return nil, fmt.Errorf("bad GIT_WALK_OPTS: only options can be given, not --")
+		}
+	}
+	return opts, nil
+}
+
 func main() {
+	if isAskpass() {
+		os.Exit(askpass(os.Args[1:]))
+	}
+
 	var (
 		help        = false
 		debug       = false
 		quiet       = false
-		where       = cwd()
+		wheres      stringList
 		serial      = false
 		parallel    = true
-		concurrency = 20
+		jobs        = "20"
+		match       stringList
+		inGroups    stringList
+		configFile  = ""
+		dirty       = false
+		branch      stringList
+		notBranch   stringList
+		remote      stringList
+		hasFile     stringList
+		maxDepth    = -1
+		minDepth    = 0
+		follow      = false
+		nested      = false
+		excludes    = strings.Join(defaultExcludes, ",")
+		noExcludes  = false
+		oneFS       = false
+		skipFS      = ""
+		submodules  = false
+		null        = false
+		stdin       = false
+		fromFile    = ""
+		cache       = false
+		cacheTTL    = 24 * time.Hour
+		refresh     = false
+		walkers     = 8
+		stream      = false
+		ordered     = false
+		timeout     time.Duration
+		deadline    time.Duration
+		failFast    = false
+		summary     = false
+		retries     = 0
+		retryDelay  = 2 * time.Second
+		perHost     = 0
+		dryRun      = false
+		confirm     = false
+		confirmOnce = false
+		script      = ""
+		steps       stringList
+		predicates  stringList
+		spawnGit    = false
+		useGC       = false
+		maxSize     = "5M"
+		ignoreCase  = false
+		fixed       = false
+		word        = false
+		asJSON      = false
+		history     = false
+		pastRuns    = 20
+		noHistory   = false
+		maintained  = 24 * time.Hour
+		byBranch    = false
+		olderThan   = ""
+		newerThan   = ""
+		outFile     = ""
+		format      *string
+		cloneFrom   = ""
+		cloneTo     = ""
+		githubOrg   = ""
+		gitlabGroup = ""
+		workspace   = ""
+		apiURL      = ""
+		mrconfig    = ""
+		mrCheckout  = false
+		showTUI     = false
+		pick        = false
+		listen      = "localhost:8080"
+		rescan      = 5 * time.Minute
+		allowRun    = false
+		tokenFile   = ""
+		watching    = false
+		watchDelay  = 500 * time.Millisecond
+		metricsAt   = ""
+		reportTo    stringList
+		outFormat   = ""
+		fieldList   = defaultFields
+		logDir      = ""
+		logOnly     = false
+		grouped     = false
+		outliers    = false
+		skipEmpty   = false
+		forceColors = false
+		stripColors = false
+		showBar     = false
+		slowest     = 0
+		niceness    = 0
+		waitLock    = false
+		noLock      = false
+		ionice      = ""
+		cloneSSH    = false
+		onHosts     stringList
+		shipIt      = false
 	)
 
-	getopt.SetParameters("[-- command...]")
+	getopt.SetParameters("[command [options]] [-- command...]")
 	getopt.FlagLong(&help, "help", 'h',
 		"Print this helpful message and exit")
+	listAlias := false
+	getopt.FlagLong(&listAlias, "list", 'l',
+		"List the repos found, as the list command does")
+	getopt.Flag(&null, '0',
+		"Terminate listed repos with NUL, not newline, as list -0 does")
+	showVersion := false
+	getopt.FlagLong(&showVersion, "version", 0,
+		"Print the version of git-walk, and how it was built, and exit")
 	getopt.FlagLong(&debug, "debug", 'd',
-		"Print debug trace")
+		"Log everything, as --log-level=debug does")
+	logLevel := getopt.EnumLong("log-level", 0, logLevels, "warn",
+		"Log what is at least as important as the level given", "debug|info|warn|error")
+	logFormat := getopt.EnumLong("log-format", 0, []string{"text", "json"}, "text",
+		"Log as key=value text, or JSON, one line per event", "text|json")
 	getopt.FlagLong(&quiet, "quiet", 'q',
 		"Do not print commands that are being run")
-	getopt.FlagLong(&where, "where", 'w',
-		"Look for git repos in `W` and below", "W")
+	getopt.FlagLong(&wheres, "where", 'w',
+		"Look for git repos in `W` and below (repeatable)", "W")
+	getopt.FlagLong(&maxDepth, "max-depth", 0,
+		"Don't look for git repos more than `N` levels below W", "N")
+	getopt.FlagLong(&minDepth, "min-depth", 0,
+		"Don't look for git repos less than `N` levels below W", "N")
+	getopt.FlagLong(&follow, "follow-symlinks", 'L',
+		"Follow symlinks to directories")
+	getopt.FlagLong(&nested, "nested", 0,
+		"Look for git repos inside of git repos")
+	getopt.FlagLong(&excludes, "default-excludes", 0,
+		"Don't look for git repos in directories with these comma separated names", "N,...")
+	getopt.FlagLong(&noExcludes, "no-default-excludes", 0,
+		"Look for git repos in every directory, even those of --default-excludes")
+	getopt.FlagLong(&oneFS, "one-file-system", 0,
+		"Don't look for git repos on other filesystems than that of W")
+	getopt.FlagLong(&skipFS, "skip-fs", 0,
+		"Don't look for git repos on filesystems of these comma separated types, like nfs,cifs,fuse", "T,...")
+	bare := getopt.EnumLong("bare", 0, []string{"skip", "include", "only"}, "skip",
+		"Whether to skip, include, or only find bare repos", "skip|include|only")
+	getopt.FlagLong(&onHosts, "host", 0,
+		"Look for repos, and run in them, on `H`, over ssh, instead of here (repeatable)", "H")
+	getopt.FlagLong(&shipIt, "ship", 0,
+		"Copy git-walk to each --host, to run there, instead of the git-walk on its PATH")
+	worktrees := getopt.EnumLong("worktrees", 0, []string{"all", "primary-only", "skip-linked"}, "all",
+		"Run in all linked worktrees, only in the main worktree of each repo, or skip linked ones", "all|primary-only|skip-linked")
+	vcsList := "git"
+	getopt.FlagLong(&vcsList, "vcs", 0,
+		"Find the repos of the VCSs `V,...`: git, hg, svn, or jj", "V,...")
+	getopt.FlagLong(&submodules, "recurse-submodules", 0,
+		"Also run in the initialized submodules of each repo")
+	getopt.FlagLong(&dryRun, "dry-run", 'N',
+		"Print the commands that would be run, without running them")
+	getopt.FlagLong(&confirm, "confirm", 'i',
+		"Ask before running the command in each repo")
+	getopt.FlagLong(&confirmOnce, "confirm-once", 0,
+		"Ask once, before running the command in the first repo")
+	getopt.FlagLong(&stdin, "stdin", 0,
+		"Read the repos to run in from stdin, instead of looking for them")
+	stdinEach := false
+	getopt.FlagLong(&stdinEach, "stdin-each", 0,
+		"Read stdin once, and give it to each command run as its stdin")
+	runHere := false
+	getopt.FlagLong(&runHere, "here", 0,
+		"Run the command where git-walk was run, rather than in each repo, with {} replaced by the repo's path")
+	batchSize := 0
+	getopt.FlagLong(&batchSize, "batch", 0,
+		"Run the command once for each `N` repos, where git-walk was run, with their paths after its arguments", "N")
+	getopt.FlagLong(&fromFile, "from-file", 0,
+		"Read the repos to run in from `F`, instead of looking for them", "F")
+	getopt.FlagLong(&mrconfig, "from-mrconfig", 0,
+		"Run in the repos listed in the myrepos config `F`, instead of looking for them", "F")
+	getopt.FlagLong(&mrCheckout, "mr-checkout", 0,
+		"Check out the repos listed in --from-mrconfig that are missing")
+	getopt.FlagLong(&cache, "cache", 0,
+		"Remember the repos found in W, and reuse them in later runs")
+	getopt.FlagLong(&cacheTTL, "cache-ttl", 0,
+		"Look for repos again if the cache is older than `D`", "D")
+	getopt.FlagLong(&refresh, "refresh-cache", 0,
+		"Look for repos again, and update the cache")
+	allowEmpty := false
+	getopt.FlagLong(&allowEmpty, "allow-empty", 0,
+		"Succeed, rather than fail, if no repos are found")
+	walkErrorPolicy := getopt.EnumLong("walk-errors", 0, []string{"ignore", "warn", "fail"}, "warn",
+		"Whether to ignore errors looking for repos, warn of them, or stop at the first", "ignore|warn|fail")
+	getopt.FlagLong(&walkers, "walkers", 0,
+		"Look for repos in this many directories in parallel", "N")
 	getopt.FlagLong(&serial, "serial", '1',
 		"Run serially")
 	getopt.FlagLong(&parallel, "parallel", 'p',
 		"Run commands in parallel")
-	getopt.Flag(&concurrency, 'n',
-		"Run this many commmands in parallel", "CONCURENCY")
-	getopt.Parse()
-	cmd := getopt.Args()
+	jobsSet := getopt.Flag(&jobs, 'n',
+		"Run this many commmands in parallel, or with auto, as many as the commands suit", "CONCURENCY")
+	getopt.FlagLong(&stream, "stream", 's',
+		"Print output as it is written, each line prefixed by its repo")
+	getopt.FlagLong(&ordered, "ordered", 'o',
+		"Print output in order of repo path, not as commands complete")
+	sortBy := getopt.EnumLong("sort", 0, []string{"path", "duration", "status"}, "path",
+		"Order output and reports by repo path, slowest first, or failures first", "path|duration|status")
+	getopt.FlagLong(&timeout, "timeout", 't',
+		"Kill commands that run for longer than `D`", "D")
+	niced := getopt.FlagLong(&niceness, "nice", 0,
+		"Run commands at niceness `N`, from -20, the highest priority, to 19", "N")
+	getopt.FlagLong(&ionice, "ionice", 0,
+		"On Linux, run commands at the I/O priority `C`, idle, best-effort, or realtime, and level L, 0 to 7", "C[:L]")
+	getopt.FlagLong(&perHost, "per-host", 0,
+		"Run at most `N` commands at once for repos with the same remote host", "N")
+	getopt.FlagLong(&deadline, "deadline", 0,
+		"Stop looking for repos and running commands after `D`", "D")
+	getopt.FlagLong(&waitLock, "wait", 0,
+		"Wait for another git-walk running commands in W to finish, rather than fail")
+	getopt.FlagLong(&noLock, "no-lock", 0,
+		"Run commands in W even if another git-walk is running them")
+	getopt.FlagLong(&noHistory, "no-history", 0,
+		"Don't save the run in the history")
+	noPrompts := false
+	getopt.FlagLong(&noPrompts, "no-prompts", 0,
+		"Don't ask for the passwords and the like that commands run in the background ask for, failing them instead")
+	getopt.FlagLong(&failFast, "fail-fast", 0,
+		"Stop running commands after the first one fails")
+	getopt.FlagLong(&retries, "retry", 0,
+		"Retry failed commands up to `N` times", "N")
+	getopt.FlagLong(&retryDelay, "retry-delay", 0,
+		"Wait `D` before the first retry, doubling for each after", "D")
+	getopt.FlagLong(&showTUI, "tui", 0,
+		"Show a live table of the repos being run in, to read, rerun, or skip them")
+	getopt.FlagLong(&pick, "pick", 0,
+		"Choose the repos to run in from those found, with a fuzzy search")
+	getopt.FlagLong(&watching, "watch", 0,
+		"Once done, keep running in each repo again whenever its files or refs change")
+	getopt.FlagLong(&watchDelay, "watch-delay", 0,
+		"Wait for a repo to stop changing for `D` before running in it again", "D")
+	getopt.FlagLong(&metricsAt, "metrics", 0,
+		"With --watch, serve Prometheus metrics at http://`A`/metrics", "A")
+	getopt.FlagLong(&reportTo, "report", 0,
+		"Once done, write a report of each repo run in, as FORMAT to FILE (repeatable), see below", "FORMAT=FILE")
+	getopt.FlagLong(&outFormat, "format", 0,
+		"Once done, print a report of each repo run in as `F`, instead of their output", "F")
+	getopt.FlagLong(&fieldList, "fields", 0,
+		"The comma separated columns of csv and tsv reports", "F,...")
+	statsAt := ""
+	getopt.FlagLong(&statsAt, "stats-json", 0,
+		"On exiting, write metrics of the run, like how many repos were found, and how long it took, as JSON to `F`", "F")
+	getopt.FlagLong(&logDir, "log-dir", 0,
+		"Write each repo's output, and how it ran, to `D`/REPO.log", "D")
+	getopt.FlagLong(&logOnly, "log-only", 0,
+		"With --log-dir, don't print each repo's output")
+	resultsAt := ""
+	getopt.FlagLong(&resultsAt, "results-dir", 0,
+		"Write how running in each repo went, as JSON, and its output, to `D`, with an index.json of the run", "D")
+	var jobLogAt, resumeFrom, retryFrom string
+	getopt.FlagLong(&jobLogAt, "job-log", 0,
+		"Append a line of JSON to `F` as running in each repo starts, and ends", "F")
+	getopt.FlagLong(&resumeFrom, "resume", 0,
+		"Skip the repos the job log `F` records as having succeeded, and log to it", "F")
+	getopt.FlagLong(&retryFrom, "retry-failed", 0,
+		"Run only in the repos the job log `F` records as failed, or unfinished, and log to it", "F")
+	getopt.FlagLong(&grouped, "group-output", 0,
+		"Once done, print each output once, after the repos that printed it")
+	getopt.FlagLong(&outliers, "outliers", 0,
+		"Once done, print only the repos whose output differs from most, and how")
+	pathFormat := getopt.EnumLong("path-format", 0, []string{"rel", "abs", "name"}, "",
+		"Refer to repos by their path relative to W, absolute path, or directory name", "rel|abs|name")
+	var header, footer string
+	getopt.FlagLong(&header, "header", 0,
+		"Write the template `T` before each repo's output, in place of the commands run", "T")
+	getopt.FlagLong(&footer, "footer", 0,
+		"Write the template `T` after each repo's output", "T")
+	getopt.FlagLong(&skipEmpty, "skip-empty", 0,
+		"Print nothing for repos where the command succeeds without output")
+	color := getopt.EnumLong("color", 0, []string{"auto", "always", "never"}, "auto",
+		"Color the lines about each command by how it went: always, never, or on a terminal", "auto|always|never")
+	getopt.FlagLong(&forceColors, "force-color", 0,
+		"Make commands color their output, though it is captured, not written to a terminal")
+	getopt.FlagLong(&stripColors, "strip-color", 0,
+		"Strip colors from the output of commands, and don't color the lines about them")
+	getopt.FlagLong(&showBar, "progress", 0,
+		"On a terminal, show how many repos are done and running, and about how long the rest will take")
+	getopt.FlagLong(&summary, "summary", 'S',
+		"Print a summary of which commands failed, once all are done")
+	getopt.FlagLong(&slowest, "timing", 0,
+		"Once done, print how long running in the `N` slowest repos took, and the total and median", "N")
+	exitCode := getopt.EnumLong("exit-code", 0, []string{"any", "all", "never"}, "any",
+		"Exit with failure if any, all, or never any commands fail", "any|all|never")
+	getopt.FlagLong(&match, "match", 'm',
+		"Only run in repos matching `P` (repeatable)", "P")
+	getopt.FlagLong(&inGroups, "group", 'g',
+		"Only run in repos in the group `G` of the config file (repeatable)", "G")
+	getopt.FlagLong(&configFile, "config", 0,
+		"Read groups, settings of repos, and aliases from the config file `F`, instead of git-walk/config.yaml in the user's config directory", "F")
+	getopt.FlagLong(&predicates, "if", 0,
+		"Only run in repos where `C` succeeds (repeatable)", "C")
+	var beforeEach, afterEach, beforeAll, afterAll string
+	getopt.FlagLong(&beforeEach, "before-each", 0,
+		"Run the shell script `S` in each repo first, failing it if S fails", "S")
+	getopt.FlagLong(&afterEach, "after-each", 0,
+		"Run the shell script `S` in each repo after, with $GIT_WALK_STATUS and $GIT_WALK_EXIT", "S")
+	getopt.FlagLong(&beforeAll, "before-all", 0,
+		"Run the shell script `S` before running in any repo, stopping if S fails", "S")
+	getopt.FlagLong(&afterAll, "after-all", 0,
+		"Run the shell script `S` once done, with counts of how running went", "S")
+	getopt.FlagLong(&dirty, "dirty", 0,
+		"Only run in repos with uncommitted changes")
+	stashed := false
+	getopt.FlagLong(&stashed, "stashed", 0,
+		"Only run in repos with stashes")
+	getopt.FlagLong(&olderThan, "older-than", 0,
+		"Only run in repos without a commit or change to the index in `A`, like 90d", "A")
+	getopt.FlagLong(&newerThan, "newer-than", 0,
+		"Only run in repos with a commit or change to the index in `A`, like 7d", "A")
+	getopt.FlagLong(&branch, "branch", 'b',
+		"Only run in repos on a branch matching `B` (repeatable)", "B")
+	getopt.FlagLong(&notBranch, "not-branch", 0,
+		"Only run in repos not on a branch matching `B` (repeatable)", "B")
+	getopt.FlagLong(&remote, "remote-match", 0,
+		"Only run in repos with a remote URL matching `P` (repeatable)", "P")
+	var ahead, behind, diverged bool
+	getopt.FlagLong(&ahead, "ahead", 0,
+		"Only run in repos with commits not in their upstream, and not behind it")
+	getopt.FlagLong(&behind, "behind", 0,
+		"Only run in repos behind their upstream, without commits not in it")
+	getopt.FlagLong(&diverged, "diverged", 0,
+		"Only run in repos both ahead of and behind their upstream")
+	getopt.FlagLong(&hasFile, "has-file", 0,
+		"Only run in repos with a file matching `G`, like go.mod (repeatable)", "G")
+	opts, err := defaultOptions()
+	if err != nil {
+		die(err)
+	}
+	// The walk.* git config gives defaults too, which $GIT_WALK_OPTS, and the
+	// command line, override.
+	gitConfig := walkConfig()
+	if n := gitConfig["concurrency"]; len(n) > 0 {
+		jobs = n[len(n)-1]
+	}
+	if names := gitConfig["exclude"]; len(names) > 0 {
+		excludes += "," + strings.Join(names, ",")
+	}
+	argv := append(append(os.Args[:1:1], opts...), os.Args[1:]...)
+	getopt.CommandLine.Parse(argv)
+	args := getopt.Args()
 
-	if serial {
-		concurrency = 1
+	if debug {
+		*logLevel = "debug"
 	}
+	logger, err := newLogger(os.Stderr, *logLevel, *logFormat)
+	if err != nil {
+		die(err)
+	}
+	slog.SetDefault(logger)
 
-	if len(cmd) < 1 {
-		cmd = []string{"git", "status", "--short", "-b"}
+	if help {
+		getopt.PrintUsage(os.Stdout)
+		fmt.Fprintf(os.Stdout, "%s", HELP)
+		return
 	}
 
-	log.SetFlags(log.Lshortfile)
+	if showVersion {
+		fmt.Println(versionString())
+		return
+	}
 
-	if !debug {
-		log.SetOutput(ioutil.Discard)
+	cfg, err := readConfig(configFile)
+	if err != nil {
+		die(err)
 	}
 
-	log.Println("parallel", parallel)
-	log.Println("concurrency", concurrency)
-	log.Println("cmd", cmd)
-	log.Printf("where %q\n", where)
+	// -l, and -0, are what list, and its -0, were before it was a command.
+	if listAlias {
+		if len(args) > 0 {
+			die(fmt.Errorf("--list can not be used with a command"))
+		}
+		args = []string{"list"}
+	}
 
-	if help {
-		getopt.PrintUsage(os.Stdout)
-		fmt.Fprintf(os.Stdout, "%s", HELP)
+	// Without a command, the arguments are the command to exec.
+	name := "exec"
+	if len(args) > 0 && isCommand(args[0]) && !afterDashes(argv, args) {
+		name = args[0]
+	} else if alias, ok := cfg.alias(args0(args)); ok && !afterDashes(argv, args) {
+		// An alias is exec of its command, and any arguments.
+		args = append(append([]string{name, "--"}, alias...), args[1:]...)
+	} else {
+		args = append([]string{name, "--"}, args...)
+	}
+
+	subHelp := false
+	// subOptions returns the options of the command called name.
+	subOptions := func(name string) *getopt.Set {
+		sub := getopt.New()
+		sub.SetProgram(getopt.CommandLine.Program() + " " + name)
+		sub.SetParameters("")
+		sub.FlagLong(&subHelp, "help", 'h',
+			"Print this helpful message and exit")
+		switch name {
+		case "exec":
+			sub.SetParameters("[-- command...]")
+			sub.FlagLong(&script, "shell", 'c',
+				"Run `S` with $SHELL -c, instead of a command", "S")
+			sub.FlagLong(&steps, "exec", 'x',
+				"Run `C`, split into words like a shell would (repeatable, run in order)", "C")
+		case "list":
+			sub.Flag(&null, '0',
+				"Terminate listed repos with NUL, not newline")
+		case "fetch":
+			sub.SetParameters("[-- git fetch options...]")
+			sub.FlagLong(&spawnGit, "git", 0,
+				"Run git fetch --all --prune, instead of fetching in-process")
+		case "maintenance":
+			sub.FlagLong(&useGC, "gc", 0,
+				"Run git gc --auto, instead of git maintenance run --auto")
+			sub.FlagLong(&maintained, "since", 0,
+				"Skip repos maintained less than `D` ago, or with 0, none", "D")
+		case "grep":
+			sub.SetParameters("pattern [-- pathspec...]")
+			sub.FlagLong(&ignoreCase, "ignore-case", 'i',
+				"Match the pattern whatever the case")
+			sub.FlagLong(&fixed, "fixed-strings", 'F',
+				"Match the pattern as a string, not a regexp")
+			sub.FlagLong(&word, "word-regexp", 'w',
+				"Only match the pattern as whole words")
+			sub.FlagLong(&asJSON, "json", 0,
+				"Write each match as a line of JSON")
+		case "audit":
+			sub.FlagLong(&maxSize, "max-size", 0,
+				"List files larger than `S`, like 512K or 5M", "S")
+			sub.FlagLong(&history, "history", 0,
+				"Look in the files of every commit, not only those of HEAD")
+		case "branches":
+			sub.FlagLong(&byBranch, "by-branch", 'g',
+				"Group repos by the branch they are on")
+		case "clone":
+			sub.SetParameters("[-- command...]")
+			sub.FlagLong(&cloneFrom, "from", 0,
+				"Clone the repos whose URLs are listed in `F`, one a line, into HOST/ORG/NAME", "F")
+			sub.FlagLong(&cloneTo, "into", 0,
+				"Clone into `D`, and without --where, run in the repos there, instead of in the first --where", "D")
+			sub.FlagLong(&githubOrg, "github-org", 0,
+				"Clone the repos of the GitHub organization `O`, using $GITHUB_TOKEN", "O")
+			sub.FlagLong(&gitlabGroup, "gitlab-group", 0,
+				"Clone the projects of the GitLab group `G`, using $GITLAB_TOKEN", "G")
+			sub.FlagLong(&workspace, "bitbucket-workspace", 0,
+				"Clone the repos of the Bitbucket workspace `W`, using $BITBUCKET_TOKEN", "W")
+			sub.FlagLong(&apiURL, "api-url", 0,
+				"Use the API at `U`, for a self-hosted instance", "U")
+			sub.FlagLong(&cloneSSH, "ssh", 0,
+				"Clone over SSH, instead of HTTPS")
+		case "serve":
+			sub.FlagLong(&listen, "listen", 'l',
+				"Listen for HTTP requests at `A`", "A")
+			sub.FlagLong(&rescan, "rescan", 0,
+				"Look for repos again every `D`", "D")
+			sub.FlagLong(&allowRun, "allow-run", 0,
+				"Run commands in repos when asked, with POST /run")
+			sub.FlagLong(&tokenFile, "token-file", 0,
+				"Only answer requests with the bearer token in `F`", "F")
+		case "history":
+			sub.SetParameters("[show ID | diff ID ID]")
+			sub.FlagLong(&pastRuns, "last", 0,
+				"List only the last `N` runs, or all, if 0", "N")
+		case "completion":
+			sub.SetParameters("bash|zsh|fish")
+		case "sync":
+			sub.SetParameters("manifest")
+		case "manifest":
+			sub.SetParameters("export")
+			format = sub.EnumLong("format", 'f', []string{"json", "yaml"}, "json",
+				"Write the manifest as JSON or YAML", "json|yaml")
+			sub.FlagLong(&outFile, "output", 'O',
+				"Write the manifest to `F`, instead of stdout", "F")
+		}
+		return sub
+	}
+	sub := subOptions(name)
+	sub.Parse(args)
+	cmd := sub.Args()
+	// Options may also follow the manifest's action.
+	if name == "manifest" && len(cmd) > 0 {
+		sub.Parse(cmd)
+		cmd = append(cmd[:1], sub.Args()...)
+	}
+
+	if subHelp {
+		sub.PrintUsage(os.Stdout)
+		return
+	}
+
+	if name == "history" {
+		if err := runHistory(os.Stdout, cmd, pastRuns); err != nil {
+			die(err)
+		}
+		return
+	}
+
+	if name == "completion" {
+		// The completion scripts complete group names with these.
+		if len(cmd) == 1 && cmd[0] == "groups" {
+			for _, g := range cfg.groupNames() {
+				fmt.Println(g)
+			}
+			return
+		}
+		var write func(io.Writer, *completion)
+		if len(cmd) == 1 {
+			write = completionShells[cmd[0]]
+		}
+		if write == nil {
+			die(fmt.Errorf("completion: expected bash, zsh, or fish, not %q", cmd))
+		}
+		write(os.Stdout, newCompletion(getopt.CommandLine, subOptions))
 		return
 	}
 
+	concurrency, err := strconv.Atoi(jobs)
+	if jobs != "auto" && (err != nil || concurrency < 1) {
+		die(fmt.Errorf("bad -n: %q is not a number, or auto", jobs))
+	}
+	if name == "maintenance" && !jobsSet.Seen() {
+		concurrency = maintenanceJobs
+	}
+	if serial {
+		concurrency, jobs = 1, "1"
+	}
+
+	var cmds [][]string
+	var defaulted bool // The commands are the default.
+	var hosting provider
+	switch name {
+	case "exec", "clone":
+		if len(cmd) > 0 {
+			cmds = append(cmds, cmd)
+		}
+		if script != "" {
+			cmds = append(cmds, shellCommand(script))
+		}
+		for _, step := range steps {
+			words, err := splitWords(step)
+			if err != nil {
+				die(err)
+			}
+			if len(words) > 0 {
+				cmds = append(cmds, words)
+			}
+		}
+		if len(cmds) > 1 && (len(cmd) > 0 || script != "") {
+			die(fmt.Errorf("only one of a command, --shell, or --exec can be used"))
+		}
+		if len(cmds) < 1 {
+			defaultCmd, err := cfg.defaultCommand()
+			if err != nil {
+				die(err)
+			}
+			cmds = [][]string{defaultCmd}
+			defaulted = true
+		}
+		if name == "clone" {
+			var err error
+			hosting, err = newProvider(cloneFrom, githubOrg, gitlabGroup, workspace, apiURL, os.Getenv)
+			if err != nil {
+				die(err)
+			}
+		}
+	case "fetch":
+		if len(cmd) > 0 && !spawnGit {
+			die(fmt.Errorf("fetch: git fetch options need --git"))
+		}
+		cmds = [][]string{append([]string{"git", "fetch", "--all", "--prune"}, cmd...)}
+	case "sync":
+		if len(cmd) != 1 {
+			die(fmt.Errorf("sync: expected a manifest, not %q", cmd))
+		}
+	case "grep":
+		if len(cmd) < 1 {
+			die(fmt.Errorf("grep: expected a pattern"))
+		}
+	case "manifest":
+		if len(cmd) != 1 || cmd[0] != "export" {
+			die(fmt.Errorf("manifest: expected export, not %q", cmd))
+		}
+	default:
+		if len(cmd) > 0 {
+			die(fmt.Errorf("%s: unexpected arguments %q", name, cmd))
+		}
+	}
+	list := name == "list"
+	if null && !list {
+		die(fmt.Errorf("-0 can only be used with list"))
+	}
+	running := name == "exec" || name == "fetch" || name == "clone" || name == "sync" || name == "maintenance"
+
+	// Repos on other hosts are looked for there, with the options for finding
+	// and selecting them, and only run in here.
+	var remoteArgs []string
+	if len(onHosts) > 0 {
+		switch {
+		case name != "exec" && name != "list":
+			die(fmt.Errorf("--host can only be used with exec and list"))
+		case stdin || fromFile != "" || mrconfig != "":
+			die(fmt.Errorf("--host can not be used with --stdin, --from-file, or --from-mrconfig"))
+		case watching:
+			die(fmt.Errorf("--host can not be used with --watch"))
+		case beforeEach != "" || afterEach != "":
+			die(fmt.Errorf("--host can not be used with --before-each or --after-each"))
+		}
+		remoteArgs = remoteOptions(getopt.CommandLine)
+		wheres = stringList{cwd()}
+	} else if shipIt {
+		die(fmt.Errorf("--ship can only be used with --host"))
+	}
+
+	if batchSize != 0 {
+		switch {
+		case batchSize < 0:
+			die(fmt.Errorf("bad --batch: %d", batchSize))
+		case name != "exec":
+			die(fmt.Errorf("--batch can only be used with exec"))
+		case len(onHosts) > 0 || watching || showTUI:
+			die(fmt.Errorf("--batch can not be used with --host, --watch, or --tui"))
+		case beforeEach != "" || afterEach != "" || confirm || confirmOnce:
+			die(fmt.Errorf("--batch can not be used with --before-each, --after-each, or --confirm"))
+		case logDir != "" || resultsAt != "" || header != "" || footer != "":
+			die(fmt.Errorf("--batch can not be used with --log-dir, --results-dir, --header, or --footer"))
+		case retries > 0 || perHost > 0:
+			die(fmt.Errorf("--batch can not be used with --retry or --per-host"))
+		case cfg.setsRuns():
+			die(fmt.Errorf("--batch can not be used with the command, env, or jobs settings of repos in %s", cfg.path))
+		}
+	}
+
+	if cloneTo != "" && len(wheres) == 0 {
+		wheres = stringList{cloneTo}
+	}
+
+	// Without --where, look where the walk.where git config says, or in the
+	// ghq root, for those managing clones with ghq, if there is one.
+	if len(wheres) == 0 && !stdin && fromFile == "" && mrconfig == "" {
+		if where := gitConfig["where"]; len(where) > 0 {
+			wheres = where
+		} else if root := ghqRoot(); root != "" {
+			wheres = stringList{root}
+		}
+	}
+	if len(wheres) == 0 {
+		wheres = stringList{cwd()}
+	}
+	roots, err := expandRoots(wheres)
+	if err != nil {
+		die(fmt.Errorf("bad --where: %v", err))
+	}
+	roots = uniqueRoots(roots)
+	if name == "sync" && len(roots) > 1 {
+		die(fmt.Errorf("sync can only be used with one --where"))
+	}
+
+	var reports []report
+	if outFormat != "" {
+		if reportFormats[outFormat] == nil {
+			die(fmt.Errorf("bad --format: unknown format %q", outFormat))
+		}
+		reportTo = append(reportTo, outFormat+"=-")
+		quiet = true
+	}
+	for _, spec := range reportTo {
+		rp, err := parseReport(spec)
+		if err != nil {
+			die(err)
+		}
+		reports = append(reports, rp)
+	}
+	if logDir != "" {
+		switch {
+		case !running:
+			die(fmt.Errorf("--log-dir can only be used to run commands"))
+		case stream:
+			die(fmt.Errorf("--log-dir can not be used with --stream"))
+		}
+	} else if logOnly {
+		die(fmt.Errorf("--log-only can only be used with --log-dir"))
+	}
+	var resultsTo *resultsDir
+	if resultsAt != "" {
+		switch {
+		case !running:
+			die(fmt.Errorf("--results-dir can only be used to run commands"))
+		case stream:
+			die(fmt.Errorf("--results-dir can not be used with --stream"))
+		}
+		resultsTo = newResultsDir(resultsAt)
+	}
+	if resumeFrom != "" && retryFrom != "" {
+		die(fmt.Errorf("--resume can not be used with --retry-failed"))
+	}
+	var ended map[string]string
+	if from := resumeFrom + retryFrom; from != "" {
+		if !running {
+			die(fmt.Errorf("--resume and --retry-failed can only be used to run commands"))
+		}
+		if ended, err = readJobLog(from); err != nil {
+			die(err)
+		}
+		if jobLogAt == "" {
+			jobLogAt = from
+		}
+	}
+	var journal *jobLog
+	if jobLogAt != "" {
+		if !running {
+			die(fmt.Errorf("--job-log can only be used to run commands"))
+		}
+		if journal, err = openJobLog(jobLogAt); err != nil {
+			die(err)
+		}
+	}
+	if grouped && outliers {
+		die(fmt.Errorf("--outliers can not be used with --group-output"))
+	}
+	if grouped || outliers {
+		flag := "--group-output"
+		if outliers {
+			flag, grouped = "--outliers", true
+		}
+		switch {
+		case !running:
+			die(fmt.Errorf("%s can only be used to run commands", flag))
+		case stream || showTUI:
+			die(fmt.Errorf("%s can not be used with --stream or --tui", flag))
+		case outFormat != "" || logOnly:
+			die(fmt.Errorf("%s can not be used with --format or --log-only", flag))
+		}
+		// The commands run would make each repo's output differ.
+		quiet = true
+	}
+	if forceColors && stripColors {
+		die(fmt.Errorf("only one of --force-color or --strip-color can be used"))
+	}
+	if getopt.IsSet("sort") {
+		switch {
+		case !running:
+			die(fmt.Errorf("--sort can only be used to run commands"))
+		case stream:
+			die(fmt.Errorf("--sort can not be used with --stream"))
+		}
+		// Output is ordered as sorted, as it is in reports.
+		ordered = true
+	}
+	if skipEmpty && stream {
+		die(fmt.Errorf("--skip-empty can not be used with --stream"))
+	}
+	var prio priority
+	if niced.Seen() {
+		if niceness < -20 || niceness > 19 {
+			die(fmt.Errorf("bad --nice: %d is not from -20 to 19", niceness))
+		}
+		prio.nice = &niceness
+	}
+	if ionice != "" {
+		if !ioniceSupported {
+			die(fmt.Errorf("--ionice is only supported on Linux"))
+		}
+		if prio.ionice, err = parseIONice(ionice); err != nil {
+			die(fmt.Errorf("bad --ionice: %v", err))
+		}
+	}
+	var input []byte
+	if stdinEach {
+		switch {
+		case name != "exec" && name != "clone" && name != "sync":
+			die(fmt.Errorf("--stdin-each can only be used to run commands"))
+		case stdin || fromFile == "-":
+			die(fmt.Errorf("--stdin-each can not be used with --stdin"))
+		}
+		if input, err = ioutil.ReadAll(os.Stdin); err != nil {
+			die(fmt.Errorf("read stdin failed with %v", err))
+		}
+	}
+	var headers [2]*template.Template
+	for i, t := range []struct{ name, text string }{{"header", header}, {"footer", footer}} {
+		if t.text == "" {
+			continue
+		}
+		switch {
+		case !running:
+			die(fmt.Errorf("--%s can only be used to run commands", t.name))
+		case grouped:
+			die(fmt.Errorf("--%s can not be used with --group-output or --outliers", t.name))
+		}
+		if headers[i], err = parseHeader(t.name, t.text); err != nil {
+			die(fmt.Errorf("bad --%s: %v", t.name, err))
+		}
+	}
+	if slowest > 0 && !running {
+		die(fmt.Errorf("--timing can only be used to run commands"))
+	}
+	fields, err := parseFields(fieldList)
+	if err != nil {
+		die(fmt.Errorf("bad --fields: %v", err))
+	}
+	if len(reports) > 0 {
+		switch {
+		case !running:
+			die(fmt.Errorf("--report can only be used to run commands"))
+		case stream:
+			die(fmt.Errorf("--report can not be used with --stream"))
+		}
+	}
+
+	// With auto, as many commands are run as there are CPUs, or more if they
+	// mostly wait on the network, in which case fewer are run if the remotes
+	// start refusing them.
+	var limit *jobLimit
+	if jobs == "auto" {
+		concurrency = autoJobs(name, cmds)
+		limit = newJobLimit(concurrency)
+	}
+
+	slog.Info("run", "command", name, "cmds", cmds, "where", roots,
+		"parallel", parallel, "concurrency", concurrency)
+
+	eol := '\n'
+	if null {
+		eol = 0
+	}
+
+	var filters []filter
+
+	if len(match) > 0 {
+		f, err := matchFilter(roots, match)
+		if err != nil {
+			die(err)
+		}
+		filters = append(filters, because("not matching --match", f))
+	}
+	if len(inGroups) > 0 {
+		f, err := cfg.groupFilter(roots, inGroups)
+		if err != nil {
+			die(err)
+		}
+		filters = append(filters, because("not in a --group", f))
+	}
+	if len(cfg.Repos) > 0 {
+		filters = append(filters, cfg.skipFilter(roots))
+	}
+	filters = append(filters, because("walk.skip is set", walkSkipFilter))
+	if len(branch) > 0 {
+		f, err := branchFilter(branch, false)
+		if err != nil {
+			die(err)
+		}
+		filters = append(filters, because("not on a --branch", f))
+	}
+	if len(notBranch) > 0 {
+		f, err := branchFilter(notBranch, true)
+		if err != nil {
+			die(err)
+		}
+		filters = append(filters, because("on a --not-branch", f))
+	}
+	if len(remote) > 0 {
+		f, err := remoteFilter(remote)
+		if err != nil {
+			die(err)
+		}
+		filters = append(filters, because("no remote matching --remote-match", f))
+	}
+	if len(hasFile) > 0 {
+		f, err := hasFileFilter(hasFile)
+		if err != nil {
+			die(err)
+		}
+		filters = append(filters, because("no --has-file", f))
+	}
+	if ahead || behind || diverged {
+		filters = append(filters, because("not --ahead, --behind, or --diverged, as wanted", trackingFilter(ahead, behind, diverged)))
+	}
+	if ended != nil {
+		filters = append(filters, resumeFilter(ended, retryFrom != ""))
+	}
+	if dirty {
+		filters = append(filters, because("not --dirty", dirtyFilter))
+	}
+	if stashed {
+		filters = append(filters, because("not --stashed", stashedFilter))
+	}
+	if olderThan != "" {
+		age, err := parseAge(olderThan)
+		if err != nil {
+			die(fmt.Errorf("bad --older-than: %v", err))
+		}
+		filters = append(filters, because("newer than --older-than", ageFilter(age, false)))
+	}
+	if newerThan != "" {
+		age, err := parseAge(newerThan)
+		if err != nil {
+			die(fmt.Errorf("bad --newer-than: %v", err))
+		}
+		filters = append(filters, because("older than --newer-than", ageFilter(age, true)))
+	}
+	for _, p := range predicates {
+		f, err := ifFilter(p)
+		if err != nil {
+			die(err)
+		}
+		filters = append(filters, because("--if "+p+" failed", f))
+	}
+
 	var wg sync.WaitGroup
-	dirs := make(chan string)
+	dirs := make(chan repo)
 
-	execute := func(dir string) {
-		log.Println("execute where:", dir)
-		child := exec.Command(cmd[0], cmd[1:]...)
-		child.Dir = dir
+	start := time.Now()
 
-		if concurrency == 1 {
-			child.Stderr = os.Stderr
-			child.Stdout = os.Stdout
-		} else {
-			child.Stderr = new(bytes.Buffer)
-			child.Stdout = new(bytes.Buffer)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stop := interrupter{cancel: cancel}
+	stop.trap()
+	if deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, deadline)
+		defer cancel()
+	}
+
+	if running && !dryRun && !noLock && len(onHosts) == 0 {
+		if err := lockRoots(ctx, roots, waitLock); err != nil {
+			if sig := stop.signal(); sig != nil {
+				stop.exit(sig)
+			}
+			if err == context.DeadlineExceeded {
+				fmt.Fprintf(os.Stderr, "deadline of %v exceeded, waiting for the lock\n", deadline)
+				os.Exit(exitDeadline)
+			}
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(exitFailed)
+		}
+		start = time.Now()
+	}
+	if beforeAll != "" || beforeEach != "" || afterEach != "" || afterAll != "" {
+		if !running {
+			die(fmt.Errorf("--before-each, --after-each, --before-all, and --after-all can only be used to run commands"))
+		}
+	}
+	if beforeAll != "" && !dryRun {
+		if err := runAllHook("before-all", beforeAll, roots, nil); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(exitFailed)
+		}
+	}
+
+	// Output must be captured, rather than written directly, to be reordered,
+	// reported, logged, grouped, skipped if empty, or kept from the progress line.
+	captured := ordered || showTUI || len(reports) > 0 || logDir != "" || resultsAt != "" || grouped || skipEmpty || showBar
+	rn := runner{
+		cmds:   cmds,
+		roots:  roots,
+		quiet:  quiet,
+		direct: concurrency == 1 && !stream && !captured,
+		stream: stream && !showTUI,
+		here:   runHere,
+
+		pathFormat: *pathFormat,
+		header:     headers[0],
+		footer:     headers[1],
+
+		input: input,
+
+		beforeEach: beforeEach,
+		afterEach:  afterEach,
+
+		config:    cfg,
+		defaulted: defaulted,
+
+		// Commands run directly, one at a time, can read git-walk's stdin,
+		// unless the repos are read from it, or it was already read.
+		inherit: concurrency == 1 && !stream && !captured && !stdin && fromFile != "-" && !stdinEach,
+
+		skipEmpty: skipEmpty,
+		colorOut:  useColor(*color, os.Stdout) && !stripColors,
+		colorErr:  useColor(*color, os.Stderr) && !stripColors,
+
+		forceColor: forceColors,
+		stripColor: stripColors,
+
+		timeout:  timeout,
+		priority: prio,
+		ctx:      ctx,
+
+		interrupt: stop.interrupt,
+
+		retries:    retries,
+		retryDelay: retryDelay,
+		logDir:     logDir,
+		resultsTo:  resultsTo,
+	}
+	if showTUI {
+		// Commands aren't signaled from the terminal while the tui reads its
+		// keys, so one dying of a signal didn't come of git-walk being.
+		rn.interrupt = nil
+	}
+	var syncing *syncer
+	if name == "sync" {
+		m, err := readManifest(cmd[0])
+		if err != nil {
+			die(fmt.Errorf("read %q failed with %v", cmd[0], err))
+		}
+		syncing = newSyncer(roots[0], m)
+		rn.cmds = [][]string{{"sync"}}
+		rn.call = syncing.sync
+	}
+	if name == "fetch" && !spawnGit {
+		rn.cmds = [][]string{{"fetch", "--all", "--prune"}}
+		rn.call = fetchRepo
+	}
+	if name == "grep" {
+		grepping := newGrepper(cmd[0], cmd[1:], ignoreCase, fixed, word, asJSON)
+		grepping.name = rn.display
+		rn.cmds = [][]string{append([]string{"git"}, grepping.args...)}
+		rn.call = grepping.grep
+		// The matches name their repos.
+		rn.quiet = true
+	}
+	var maintaining *maintainer
+	if name == "maintenance" {
+		if maintaining, err = newMaintainer(useGC); err != nil {
+			die(err)
+		}
+		rn.cmds = [][]string{maintaining.command()}
+		rn.call = maintaining.maintain
+		if maintained > 0 {
+			filters = append(filters, maintaining.due(maintained))
+		}
+	}
+	if ordered && !showTUI && !grouped {
+		rn.order = newOrder()
+	}
+
+	var excluded []string
+	if !noExcludes {
+		for _, name := range strings.Split(excludes, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				excluded = append(excluded, name)
+			}
+		}
+	}
+	var skippedFS []string
+	for _, fs := range strings.Split(skipFS, ",") {
+		if fs = strings.TrimSpace(fs); fs != "" {
+			skippedFS = append(skippedFS, fs)
 		}
+	}
+	if len(skippedFS) > 0 && !fsTypesKnown {
+		die(fmt.Errorf("--skip-fs is not supported on this system"))
+	}
+	findVCS, err := parseVCS(vcsList)
+	if err != nil {
+		die(fmt.Errorf("bad --vcs: %v", err))
+	}
+	newWalker := func(root string, found func(repo)) *walker {
+		return &walker{
+			root:     root,
+			maxDepth: maxDepth,
+			minDepth: minDepth,
+			follow:   follow,
+			nested:   nested,
+			bare:     *bare,
+			excludes: excluded,
+			oneFS:    oneFS,
+			skipFS:   skippedFS,
+
+			errorPolicy: *walkErrorPolicy,
+
+			submodules: submodules,
+			vcses:      findVCS,
+			jobs:       walkers,
+
+			found: found,
+			ctx:   ctx,
+		}
+	}
 
-		err := child.Run()
+	if name == "serve" {
+		// Output is only ever sent in responses, and a command dying of a
+		// signal only fails the request it was run for.
+		rn.quiet, rn.direct, rn.stream, rn.order = true, false, false, nil
+		rn.interrupt = nil
+		token, err := readToken(tokenFile)
+		if err != nil {
+			die(fmt.Errorf("bad --token-file: %v", err))
+		}
+		if allowRun && token == "" {
+			die(fmt.Errorf("serve: --allow-run can only be used with --token-file"))
+		}
+		s := &server{rn: rn, filters: filters, jobs: concurrency, metrics: newMetrics(), allowRun: allowRun, token: token}
+		s.walk = func() ([]repo, int) {
+			var repos []repo
+			errors := 0
+			found := foundOnce(func(r repo) {
+				r.seq = len(repos)
+				repos = append(repos, r)
+			})
+			for _, root := range roots {
+				w := newWalker(root, found)
+				w.walk()
+				errors += w.failures()
+			}
+			return repos, errors
+		}
+		if err := s.serve(ctx, listen, rescan); err != nil {
+			fmt.Fprintf(os.Stderr, "serve %q failed with %v\n", listen, err)
+			os.Exit(exitFailed)
+		}
+		if sig := stop.signal(); sig != nil {
+			stop.exit(sig)
+		}
+		os.Exit(exitDeadline)
+	}
 
-		output.Lock()
-		defer output.Unlock()
-		if err == nil {
-			if !quiet {
-				fmt.Printf("cd %s; %s\n", dir, strings.Join(cmd, " "))
+	results := newTally()
+	rn.results = results
+	if len(reports) > 0 {
+		rn.ran = newOutcomes()
+	}
+	if limit != nil {
+		rn.throttled = func(r repo) {
+			if n, ok := limit.lower(); ok {
+				output.Lock()
+				clearProgress()
+				fmt.Fprintf(os.Stderr, "rate limited in %s, running %d commands at once\n", rn.display(r), n)
+				output.Unlock()
 			}
+		}
+	}
+	if outFormat != "" || logOnly {
+		// The output is in the report, or the logs.
+		rn.sink = func(repo, []byte, []byte) {}
+	}
+	var groups outputGroups
+	if grouped {
+		groups.path = rn.path
+		rn.sink = groups.add
+	}
 
-		} else if eexit, ok := err.(*exec.ExitError); ok {
-			fmt.Fprintf(os.Stderr, "cd %s: `%s` failed on %v\n",
-				dir, strings.Join(cmd, " "), eexit)
+	// Why the run was stopped early, other than being signaled, or running
+	// out of time.
+	var stopped string
+	var stopOnce sync.Once
+	stopRun := func(why string) {
+		stopOnce.Do(func() {
+			stopped = why
+			cancel()
+		})
+	}
+
+	var table *statusTable
+	if name == "status" || name == "branches" {
+		table = &statusTable{}
+	}
 
-			// If child was signaled, self-terminate with the same signal.
-			status, ok := eexit.Sys().(syscall.WaitStatus)
-			self, _ := os.FindProcess(os.Getpid())
-			if ok && status.Signaled() {
-				self.Signal(status.Signal())
+	var ask *confirmer
+	if confirm || confirmOnce {
+		ask = newConfirmer(confirmOnce)
+	}
+
+	if watching {
+		switch {
+		case table != nil || name == "dupes" || name == "audit" || name == "manifest":
+			die(fmt.Errorf("--watch can not be used with %s", name))
+		case showTUI:
+			die(fmt.Errorf("--watch can not be used with --tui"))
+		case ask != nil:
+			die(fmt.Errorf("--watch can not be used with --confirm"))
+		}
+	}
+	var met *metrics
+	if metricsAt != "" {
+		if !watching {
+			die(fmt.Errorf("--metrics can only be used with --watch"))
+		}
+		met = newMetrics()
+	}
+
+	var ui *tui
+	if showTUI {
+		switch {
+		case !running:
+			die(fmt.Errorf("--tui can only be used to run commands"))
+		case ask != nil:
+			die(fmt.Errorf("--tui can not be used with --confirm"))
+		case list || dryRun:
+			die(fmt.Errorf("--tui can not be used with --dry-run"))
+		}
+		ui = newTUI(rn.display)
+		rn.sink = ui.output
+		ui.quit = func() {
+			if !ui.isDone() {
+				stopRun("quit")
+			}
+		}
+	}
+
+	execute := func(r repo) {
+		if ui != nil {
+			if ui.skipping(r) {
+				results.record(r, skipped)
+				ui.finished(r, skipped)
+				return
+			}
+			ui.started(r)
+		}
+		if ask != nil {
+			switch ask.confirm(&rn, r) {
+			case no:
+				rn.emit(r, nil, nil)
+				results.record(r, skipped)
+				return
+			case quit:
+				stopRun("quit at " + r.dir)
+				return
 			}
+		}
+		began := time.Now()
+		if journal != nil {
+			journal.started(&rn, r)
+		}
+		st := rn.execute(r)
+		results.record(r, st)
+		if journal != nil {
+			journal.ended(&rn, r, st)
+		}
+		results.timed(r, time.Since(began))
+		if met != nil {
+			met.ran(commandName(rn.cmds), st, time.Since(began))
+		}
+		if ui != nil {
+			ui.finished(r, st)
+		}
+		if st == failed && failFast {
+			stopRun("stopped after failure in " + r.dir)
+		}
+	}
+	if ui != nil {
+		ui.rerun = execute
+	}
+
+	// Batches of repos are run in together, and went as the batch did.
+	var batches *batcher
+	if batchSize > 0 {
+		batches = newBatcher(batchSize, func(rs []repo) {
+			if dryRun {
+				rn.emit(rs[0], []byte(rn.batchBanner(rs)), nil)
+				for _, r := range rs {
+					if r.seq != rs[0].seq {
+						rn.emit(r, nil, nil)
+					}
+					results.record(r, succeeded)
+				}
+				return
+			}
+			began := time.Now()
+			for _, r := range rs {
+				if journal != nil {
+					journal.started(&rn, r)
+				}
+			}
+			st := rn.executeBatch(rs)
+			for _, r := range rs {
+				results.record(r, st)
+				if journal != nil {
+					journal.ended(&rn, r, st)
+				}
+				results.timed(r, time.Since(began))
+			}
+			if st == failed && failFast {
+				stopRun("stopped after failure in the batch of " + rs[0].dir)
+			}
+		})
+	}
+
+	var dupes dupeFinder
+	var audits *auditor
+	if name == "audit" {
+		size, err := parseSize(maxSize)
+		if err != nil {
+			die(fmt.Errorf("bad --max-size: %v", err))
+		}
+		audits = newAuditor(size, history)
+	}
+	var exported manifestWriter
+
+	var hosts *hostLimiter
+	if perHost > 0 {
+		hosts = newHostLimiter(perHost)
+	}
+
+	var meter *progress
+	if showBar && !showTUI && !pick {
+		meter = newProgress(rn.display)
+		meter.show()
+	}
+
+	// process runs in r, or reads it, as the command requires.
+	// Repos on other hosts were already selected there.
+	process := func(r repo) {
+		if r.host == "" && !selected(filters, r.dir) {
+			rn.emit(r, nil, nil)
+			results.record(r, skipped)
+			if ui != nil {
+				ui.finished(r, skipped)
+			}
+		} else if r.vcs != "" && !list && name != "exec" && name != "clone" {
+			// Only commands run in repos of any VCS.
+			slog.Debug("skip", "repo", r.dir, "reason", "a "+r.vcs+" repo")
+			rn.emit(r, nil, nil)
+			results.record(r, skipped)
+			if ui != nil {
+				ui.finished(r, skipped)
+			}
+		} else if list {
+			dir := r.dir
+			if r.host != "" {
+				dir = rn.name(r)
+			}
+			rn.emit(r, []byte(fmt.Sprintf("%s%c", dir, eol)), nil)
+			results.record(r, succeeded)
+		} else if name == "manifest" {
+			if m, err := manifestRepoOf(rn.rootOf(r), r); err != nil {
+				fmt.Fprintf(os.Stderr, "manifest %q failed with %v\n", r.dir, err)
+				results.record(r, failed)
+			} else {
+				exported.add(m)
+				results.record(r, succeeded)
+			}
+		} else if name == "dupes" {
+			if c, ok := cloneOf(r); ok {
+				dupes.add(c)
+				results.record(r, succeeded)
+			} else {
+				results.record(r, skipped)
+			}
+		} else if audits != nil {
+			if n, err := audits.audit(r); err != nil {
+				fmt.Fprintf(os.Stderr, "audit %q failed with %v\n", r.dir, err)
+				results.record(r, failed)
+			} else if n > 0 {
+				// Findings fail the repo, for audits run in CI.
+				results.record(r, failed)
+			} else {
+				results.record(r, succeeded)
+			}
+		} else if name == "branches" {
+			_, st := readHead(r)
+			table.add(st)
+			results.record(r, succeeded)
+		} else if table != nil {
+			st := readStatus(r)
+			table.add(st)
+			if st.err != nil {
+				results.record(r, failed)
+			} else {
+				results.record(r, succeeded)
+			}
+		} else if name == "doctor" {
+			if why := doctorReasons(r); len(why) > 0 {
+				line := fmt.Sprintf("%s: %s\n", rn.display(r), strings.Join(why, ", "))
+				rn.emit(r, []byte(line), nil)
+				results.record(r, succeeded)
+			} else {
+				rn.emit(r, nil, nil)
+				results.record(r, skipped)
+			}
+		} else if name == "dirty" {
+			st := readStatus(r)
+			if why := dirtyReasons(st); len(why) > 0 {
+				line := fmt.Sprintf("%s: %s\n", rn.display(r), strings.Join(why, ", "))
+				rn.emit(r, []byte(line), nil)
+				results.record(r, succeeded)
+			} else {
+				rn.emit(r, nil, nil)
+				results.record(r, skipped)
+			}
+		} else if batches != nil {
+			batches.add(r)
+		} else if dryRun {
+			rn.emit(r, []byte(rn.banner(r)), nil)
+			results.record(r, succeeded)
+		} else if hosts == nil {
+			execute(r)
 		} else {
-			fmt.Fprintf(os.Stderr, "cd %s: `%s` failed on %v\n",
-				dir, strings.Join(cmd, " "), err)
+			// Run r, if its host isn't too busy, and then whatever
+			// else was waiting for that host.
+			host := remoteHost(r.dir)
+			ok := hosts.start(host, r)
+			if !ok {
+				slog.Debug("wait for host", "repo", r.dir, "host", host)
+			}
+			for ; ok; r, ok = hosts.done(host) {
+				execute(r)
+			}
 		}
-		if concurrency != 1 {
-			os.Stdout.Write(child.Stdout.(*bytes.Buffer).Bytes())
-			os.Stderr.Write(child.Stderr.(*bytes.Buffer).Bytes())
+	}
+
+	// Commands run in the background ask for passwords, and the like,
+	// through git-walk, at the terminal, rather than waiting on it forever.
+	var relay *promptRelay
+	switch {
+	case noPrompts:
+		os.Setenv("GIT_TERMINAL_PROMPT", "0")
+	case running && !dryRun && !rn.direct && !showTUI:
+		if relay, err = newPromptRelay(ctx); err != nil {
+			// Commands ask for themselves, as they would without the relay.
+			slog.Debug("prompts can't be relayed", "err", err)
+		} else {
+			relay.setenv(concurrency > 1)
 		}
 	}
 
+	// How the run is going is written when asked, by signal.
+	flights := newFlight(results)
+	stopDump := flights.notify(rn.display)
+
 	for i := 0; i < concurrency; i++ {
 		wg.Add(1)
 		go func() {
-			for dir := range dirs {
-				execute(dir)
+			for r := range dirs {
+				if ctx.Err() == nil {
+					limit.start()
+					meter.started(r)
+					flights.started(r)
+					process(r)
+					flights.stopped(r)
+					meter.stopped(r)
+					limit.done()
+				}
 			}
 			wg.Done()
 		}()
 	}
 
-	walker := func(path string, info os.FileInfo, err error) (_ error) {
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "walk %q failed with %v\n", path, err)
-			return
+	// The tui is shown while repos are looked for and run in, until the user
+	// quits, or once they are picked.
+	shown := make(chan error, 1)
+	if ui != nil && !pick {
+		go func() { shown <- ui.run() }()
+	}
+
+	var repos []repo
+	run := func(r repo) {
+		r.seq = len(repos)
+		repos = append(repos, r)
+		meter.add()
+		flights.add(r)
+		if ui != nil {
+			ui.add(r)
 		}
-		if !info.IsDir() {
-			return
+		select {
+		case dirs <- r:
+		case <-ctx.Done():
 		}
-		infos, err := ioutil.ReadDir(path)
+	}
+	found := run
+
+	// Repos to pick from are only run in once all are found, and chosen, of
+	// those that pass the filters.
+	var candidates []repo
+	if pick {
+		found = func(r repo) {
+			if r.host != "" || selected(filters, r.dir) {
+				candidates = append(candidates, r)
+			}
+		}
+	}
+	found = worktreesFound(*worktrees, found)
+
+	if stdin {
+		fromFile = "-"
+	}
+
+	walkErrors := 0
+	walkFailures := 0 // Of the walkers, whatever the policy.
+	failWalk := func() { stopRun("stopped after error looking for repos") }
+
+	if name == "clone" {
+		hosted, err := hosting.repos(ctx, cloneSSH)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "readdir %q failed with %s\n", path, err)
-			return
+			fmt.Fprintf(os.Stderr, "list repos failed with %v\n", err)
+			walkErrors++
+		}
+		into := roots[0]
+		if cloneTo != "" {
+			into = expandHome(cloneTo)
 		}
+		results.uncloned = cloneMissing(ctx, into, hosted, concurrency, perHost, quiet)
+		walkErrors += len(results.uncloned)
+	}
 
-		for _, info := range infos {
-			if info.IsDir() && info.Name() == ".git" {
-				dirs <- path
-				return filepath.SkipDir
+	// Each root is walked in turn, with repos in more than one, which overlap,
+	// only being found in the first.
+	// Repos are found in no particular order, walking directories in
+	// parallel, so those listed, or run in one at a time, are held until
+	// each root is walked, and found in the order of their paths.
+	inOrder := (list || concurrency == 1) && rn.order == nil
+	walkRoots := func(walk func(w *walker)) {
+		once := foundOnce(found)
+		for _, root := range roots {
+			w := newWalker(root, once)
+			var held []repo
+			if inOrder {
+				w.found = func(r repo) { held = append(held, r) }
+			}
+			w.failed = failWalk
+			walk(w)
+			walkFailures += w.failures()
+			sortByPath(held)
+			for _, r := range held {
+				once(r)
 			}
 		}
-		return
 	}
-	filepath.Walk(where, walker)
+	switch {
+	case len(onHosts) > 0:
+		for _, host := range onHosts {
+			bin := "git-walk"
+			if shipIt {
+				var err error
+				if bin, err = ship(ctx, host); err != nil {
+					fmt.Fprintf(os.Stderr, "ship to %q failed with %v\n", host, err)
+					walkErrors++
+					continue
+				}
+			}
+			if err := listRemote(ctx, host, bin, remoteArgs, found); err != nil {
+				fmt.Fprintf(os.Stderr, "list %q failed with %v\n", host, err)
+				walkErrors++
+			}
+		}
+	case syncing != nil:
+		// Run in the manifest's repos, and look for any others.
+		for _, r := range syncing.repos() {
+			found(r)
+		}
+		w := newWalker(roots[0], syncing.found)
+		w.failed = failWalk
+		w.walk()
+		walkFailures += w.failures()
+	case mrconfig != "":
+		listed, err := readMrconfig(expandHome(mrconfig))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "read %q failed with %v\n", mrconfig, err)
+			walkErrors++
+		}
+		for _, m := range listed {
+			if _, err := os.Stat(m.dir); os.IsNotExist(err) {
+				if !mrCheckout || m.checkout == "" {
+					slog.Debug("skip", "repo", m.dir, "reason", "not checked out")
+					continue
+				}
+				if err := m.checkoutRepo(); err != nil {
+					fmt.Fprintf(os.Stderr, "checkout %q failed with %v\n", m.dir, err)
+					walkErrors++
+					continue
+				}
+			}
+			found(repo{dir: m.dir})
+		}
+	case fromFile != "":
+		in := os.Stdin
+		if fromFile != "-" {
+			f, err := os.Open(fromFile)
+			if err != nil {
+				die(err)
+			}
+			defer f.Close()
+			in = f
+		}
+		if err := readRepos(in, found); err != nil {
+			fmt.Fprintf(os.Stderr, "read %q failed with %v\n", fromFile, err)
+			walkErrors++
+		}
+	case cache || refresh:
+		walkRoots(func(w *walker) { walkCached(w, cacheTTL, refresh) })
+	default:
+		walkRoots((*walker).walk)
+	}
+	results.walkErrors = walkFailures
+	if *walkErrorPolicy != "ignore" {
+		walkErrors += walkFailures
+	}
+	if pick && ctx.Err() == nil {
+		chosen, err := pickRepos(candidates, rn.display)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "pick failed with %v\n", err)
+			os.Exit(exitFailed)
+		}
+		if ui != nil {
+			go func() { shown <- ui.run() }()
+		}
+		// They already passed the filters, which aren't run again.
+		filters = nil
+		for _, r := range chosen {
+			run(r)
+		}
+	}
 	close(dirs)
+	rn.found(len(repos))
+	meter.allFound()
+	flights.found()
+	walked := time.Since(start)
+	// Output is written in the order of the repos' paths as they finish, or if
+	// sorted by how they went, once all have.
+	if rn.order != nil && *sortBy == "path" {
+		rn.order.sorted(repos, repoOrder(*sortBy, results))
+	}
 	wg.Wait()
+	if batches != nil && ctx.Err() == nil {
+		batches.flush()
+	}
+	stopDump()
+	meter.stop()
+	if rn.order != nil && *sortBy != "path" {
+		rn.order.sorted(repos, repoOrder(*sortBy, results))
+	}
+
+	if ui != nil {
+		ui.finish()
+		if err := <-shown; err != nil {
+			fmt.Fprintf(os.Stderr, "tui failed with %v\n", err)
+		}
+	}
+
+	if watching && ctx.Err() == nil {
+		// Reruns are written as they finish, since all repos were written,
+		// and are only stopped by git-walk being, not by a command dying of a
+		// signal.
+		rn.order = nil
+		rn.interrupt = nil
+		rerun := process
+		if met != nil {
+			// Serve the metrics, reading each repo's status as it changes.
+			mux := http.NewServeMux()
+			mux.Handle("GET /metrics", met)
+			srv := &http.Server{Addr: metricsAt, Handler: mux}
+			go func() {
+				if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+					fmt.Fprintf(os.Stderr, "serve %q failed with %v\n", metricsAt, err)
+				}
+			}()
+			defer srv.Close()
+			met.found(repos)
+			for _, r := range repos {
+				met.read(readStatus(r))
+			}
+			rerun = func(r repo) {
+				process(r)
+				met.read(readStatus(r))
+			}
+		}
+		watch, err := newWatcher(ctx, watchDelay, concurrency, rerun)
+		if err != nil {
+			die(err)
+		}
+		for _, r := range repos {
+			if err := watch.add(r); err != nil {
+				fmt.Fprintf(os.Stderr, "watch %q failed with %v\n", r.dir, err)
+			}
+		}
+		watch.run()
+	}
+
+	if relay != nil {
+		relay.close()
+	}
+
+	switch {
+	case outliers:
+		groups.writeOutliers(rn.display, results)
+	case grouped:
+		groups.write(rn.display, results)
+	}
+
+	switch {
+	case name == "branches":
+		table.writeBranches(os.Stdout, rn.display, byBranch)
+	case name == "dupes":
+		dupes.write(os.Stdout, rn.display)
+	case audits != nil:
+		audits.write(os.Stdout, rn.display)
+	case syncing != nil:
+		for _, r := range syncing.unlisted {
+			fmt.Printf("not in manifest: %s\n", rn.display(r))
+		}
+	case name == "manifest":
+		if err := writeManifest(&exported, outFile, *format); err != nil {
+			fmt.Fprintf(os.Stderr, "write %q failed with %v\n", outFile, err)
+			os.Exit(exitFailed)
+		}
+	case table != nil:
+		table.write(os.Stdout, rn.display)
+	case maintaining != nil && maintaining.total() > 0:
+		fmt.Printf("reclaimed %s in all\n", humanSize(maintaining.total()))
+	}
+
+	if len(reports) > 0 {
+		rd := newReportData(&rn, repos, results, rn.ran, start, fields, *sortBy)
+		for _, rp := range reports {
+			if err := rp.write(rd); err != nil {
+				fmt.Fprintf(os.Stderr, "report %q failed with %v\n", rp.path, err)
+				walkErrors++
+			}
+		}
+	}
+
+	if afterAll != "" && !dryRun {
+		if err := runAllHook("after-all", afterAll, roots, allHookEnv(results, repos, ctx.Err() != nil)); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			walkErrors++
+		}
+	}
+
+	if running && !dryRun && !noHistory {
+		if err := saveRun(newPastRun(&rn, repos, results, start)); err != nil {
+			fmt.Fprintf(os.Stderr, "save run failed with %v\n", err)
+		}
+	}
+
+	if journal != nil {
+		if err := journal.close(); err != nil {
+			fmt.Fprintf(os.Stderr, "job log %q failed with %v\n", jobLogAt, err)
+			walkErrors++
+		} else if ctx.Err() != nil {
+			fmt.Fprintf(os.Stderr, "to pick up where this stopped, run again with --resume %s\n", jobLogAt)
+		}
+	}
+
+	if statsAt != "" {
+		if err := newRunStats(&rn, name, repos, results, concurrency, start, walked).write(statsAt); err != nil {
+			fmt.Fprintf(os.Stderr, "write %q failed with %v\n", statsAt, err)
+			walkErrors++
+		}
+	}
+	if resultsTo != nil {
+		stats := newRunStats(&rn, name, repos, results, concurrency, start, walked)
+		if err := resultsTo.writeIndex(&rn, stats, repos, results); err != nil {
+			fmt.Fprintf(os.Stderr, "write %q failed with %v\n", resultsTo.indexPath(), err)
+			walkErrors++
+		}
+	}
+
+	if slowest > 0 {
+		results.timing(os.Stderr, repos, slowest, rn.path)
+	}
+
+	if ctx.Err() == nil {
+		if summary {
+			results.summarize(os.Stderr, repos, time.Since(start), rn.path)
+		}
+		if len(repos) == 0 && len(candidates) == 0 && !allowEmpty {
+			switch {
+			case fromFile == "-":
+				fmt.Fprintf(os.Stderr, "no repos were read from stdin\n")
+			case fromFile != "":
+				fmt.Fprintf(os.Stderr, "no repos were read from %s\n", fromFile)
+			case mrconfig != "":
+				fmt.Fprintf(os.Stderr, "no repos were read from %s\n", mrconfig)
+			case len(onHosts) > 0:
+				fmt.Fprintf(os.Stderr, "no repos were found on %s\n", strings.Join(onHosts, ", "))
+			default:
+				fmt.Fprintf(os.Stderr, "no repos were found in %s\n", strings.Join(roots, ", "))
+			}
+			os.Exit(exitEmpty)
+		}
+		os.Exit(exitStatus(*exitCode, results, repos, walkErrors))
+	}
+	// Let any ordered output after unprocessed repos be written.
+	for _, r := range results.with(repos, pending) {
+		rn.emit(r, nil, nil)
+	}
+	if sig := stop.signal(); sig != nil {
+		fmt.Fprintf(os.Stderr, "interrupted by %v, ", sig)
+		results.summarize(os.Stderr, repos, time.Since(start), rn.path)
+		stop.exit(sig)
+	}
+	if stopped != "" {
+		fmt.Fprintf(os.Stderr, "%s, ", stopped)
+		results.summarize(os.Stderr, repos, time.Since(start), rn.path)
+		os.Exit(exitFailed)
+	}
+	fmt.Fprintf(os.Stderr, "deadline of %v exceeded, ", deadline)
+	results.summarize(os.Stderr, repos, time.Since(start), rn.path)
+	os.Exit(exitDeadline)
 }
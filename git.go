@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// gitOutput runs git with args in dir, returning its trimmed stdout.
+func gitOutput(dir string, args ...string) (string, error) {
+	var out bytes.Buffer
+	git := exec.Command("git", args...)
+	git.Dir = dir
+	git.Stdout = &out
+	err := git.Run()
+	return strings.TrimSpace(out.String()), err
+}
+
+// currentBranch returns the branch HEAD is on, or "" if HEAD is detached.
+func currentBranch(dir string) string {
+	branch, _ := gitOutput(dir, "symbolic-ref", "--short", "-q", "HEAD")
+	return branch
+}
+
+// remoteURLs returns the URLs of all of the repo's remotes.
+func remoteURLs(dir string) []string {
+	out, err := gitOutput(dir, "config", "--get-regexp", `^remote\..*\.url$`)
+	if err != nil || out == "" {
+		return nil
+	}
+	var urls []string
+	for _, line := range strings.Split(out, "\n") {
+		if f := strings.Fields(line); len(f) == 2 {
+			urls = append(urls, f[1])
+		}
+	}
+	return urls
+}
+
+// normalizeRemote reduces the many spellings of a remote URL to
+// host/path form, so that https://github.com/org/repo.git,
+// git@github.com:org/repo, and ssh://git@github.com/org/repo are all
+// github.com/org/repo.
+func normalizeRemote(url string) string {
+	u := url
+	if i := strings.Index(u, "://"); i >= 0 {
+		u = u[i+3:]
+	} else if i := strings.Index(u, ":"); i >= 0 && !strings.Contains(u[:i], "/") {
+		// scp-like syntax: [user@]host:path
+		u = u[:i] + "/" + u[i+1:]
+	} else {
+		// A local path.
+		return strings.TrimSuffix(u, ".git")
+	}
+	if i := strings.Index(u, "@"); i >= 0 && i < strings.Index(u+"/", "/") {
+		u = u[i+1:]
+	}
+	if i := strings.Index(u, "/"); i >= 0 {
+		// Drop any port, which is usually shared by the scp form.
+		if j := strings.LastIndex(u[:i], ":"); j >= 0 {
+			u = u[:j] + u[i:]
+		}
+	}
+	u = strings.TrimSuffix(u, "/")
+	return strings.TrimSuffix(u, ".git")
+}
+
+// originURL returns the URL of the repo's origin remote, or of its first
+// remote if it has no origin, or "" if it has no remotes.
+func originURL(dir string) string {
+	url, err := gitOutput(dir, "config", "--get", "remote.origin.url")
+	if err != nil || url == "" {
+		urls := remoteURLs(dir)
+		if len(urls) == 0 {
+			return ""
+		}
+		url = urls[0]
+	}
+	return url
+}
+
+// remoteHost returns the host of the repo's origin URL. Returns "" if the repo
+// has no remotes, or they are local paths.
+func remoteHost(dir string) string {
+	return urlHost(originURL(dir))
+}
+
+// urlHost returns the host of the remote URL url, or "" if it is a local path.
+func urlHost(url string) string {
+	if url == "" || strings.HasPrefix(url, "file://") || !strings.Contains(url, ":") {
+		return ""
+	}
+	host := normalizeRemote(url)
+	if i := strings.Index(host, "/"); i >= 0 {
+		host = host[:i]
+	}
+	return host
+}
+
+// lastActivity returns when the repo was last committed to, or its index was
+// last written, whichever is later. Returns the zero time if neither is known.
+func lastActivity(dir string) time.Time {
+	var last time.Time
+	if out, err := gitOutput(dir, "log", "-1", "--format=%ct"); err == nil && out != "" {
+		if secs, err := strconv.ParseInt(out, 10, 64); err == nil {
+			last = time.Unix(secs, 0)
+		}
+	}
+	if index, err := gitOutput(dir, "rev-parse", "--git-path", "index"); err == nil {
+		if !filepath.IsAbs(index) {
+			index = filepath.Join(dir, index)
+		}
+		if info, err := os.Stat(index); err == nil && info.ModTime().After(last) {
+			last = info.ModTime()
+		}
+	}
+	return last
+}
+
+// ghqRoot returns the primary root of the repos managed by ghq, or "" if ghq
+// isn't configured. Like ghq, $GHQ_ROOT takes precedence over the ghq.root git
+// config, and the first of a list of roots is the primary one.
+func ghqRoot() string {
+	root := strings.Split(os.Getenv("GHQ_ROOT"), string(filepath.ListSeparator))[0]
+	if root == "" {
+		out, _ := gitOutput("", "config", "--path", "--get-all", "ghq.root")
+		root = strings.Split(out, "\n")[0]
+	}
+	if root == "" {
+		return ""
+	}
+	return expandHome(root)
+}
+
+// walkConfig returns the values of the walk.* git config, by name, such as
+// "where", in the order given, with paths like ~/src expanded.
+func walkConfig() map[string][]string {
+	out, err := gitOutput("", "config", "--path", "--get-regexp", `^walk\.`)
+	if err != nil || out == "" {
+		return nil
+	}
+	values := map[string][]string{}
+	for _, line := range strings.Split(out, "\n") {
+		key, value, _ := strings.Cut(line, " ")
+		name := strings.TrimPrefix(key, "walk.")
+		values[name] = append(values[name], value)
+	}
+	return values
+}
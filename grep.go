@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+)
+
+// A grepper searches repos with git grep, writing each match as a line of
+// REPO:PATH:LINE:TEXT, or of JSON.
+type grepper struct {
+	args []string          // Options for git grep, and the pattern, and any pathspecs.
+	json bool              // Write matches as JSON, a line per match.
+	name func(repo) string // How repos are named in matches.
+}
+
+// A grepMatch is a line matched, as written with --json.
+type grepMatch struct {
+	Repo string `json:"repo"`
+	Path string `json:"path"`
+	Line int    `json:"line"`
+	Text string `json:"text"`
+}
+
+func newGrepper(pattern string, pathspecs []string, ignoreCase, fixed, word, asJSON bool) *grepper {
+	args := []string{"grep", "-n", "-I", "-z", "--no-color"}
+	if ignoreCase {
+		args = append(args, "-i")
+	}
+	if fixed {
+		args = append(args, "-F")
+	}
+	if word {
+		args = append(args, "-w")
+	}
+	args = append(args, "-e", pattern, "--")
+	return &grepper{args: append(args, pathspecs...), json: asJSON}
+}
+
+// grep searches r, writing its matches to stdout. Finding nothing isn't a
+// failure.
+func (g *grepper) grep(ctx context.Context, r repo, stdout, stderr io.Writer) error {
+	var out bytes.Buffer
+	git := commandContext(ctx, "git", g.args...)
+	git.Dir = r.dir
+	git.Stdout = &out
+	git.Stderr = stderr
+	err := runGroup(git)
+	if eexit, ok := err.(*exec.ExitError); ok && eexit.ExitCode() == 1 && out.Len() == 0 {
+		return nil
+	}
+	name := g.name(r)
+	enc := json.NewEncoder(stdout)
+	scan := bufio.NewScanner(&out)
+	scan.Buffer(make([]byte, 64*1024), 1<<24)
+	for scan.Scan() {
+		// With -z, each match is PATH\0LINE\0TEXT.
+		fields := bytes.SplitN(scan.Bytes(), []byte{0}, 3)
+		if len(fields) != 3 {
+			continue
+		}
+		path, text := string(fields[0]), string(fields[2])
+		line, _ := strconv.Atoi(string(fields[1]))
+		if g.json {
+			enc.Encode(grepMatch{Repo: name, Path: path, Line: line, Text: text})
+		} else {
+			fmt.Fprintf(stdout, "%s:%s:%d:%s\n", name, path, line, text)
+		}
+	}
+	return err
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-git/v5/utils/diff"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// outputGroups groups repos by their output, so that repos whose commands
+// wrote the same can be written once.
+type outputGroups struct {
+	path   func(repo) string // How a repo is written in notes about it.
+	lock   sync.Mutex
+	groups map[string]*outputGroup // By output.
+}
+
+// An outputGroup is the repos that wrote the same output.
+type outputGroup struct {
+	repos          []repo
+	stdout, stderr []byte
+}
+
+// add records the output of r. It is the runner's sink.
+func (g *outputGroups) add(r repo, stdout, stderr []byte) {
+	stdout, stderr = stripNotes(stdout, g.path(r)), stripNotes(stderr, g.path(r))
+	key := string(stdout) + "\x00" + string(stderr)
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.groups == nil {
+		g.groups = map[string]*outputGroup{}
+	}
+	group := g.groups[key]
+	if group == nil {
+		group = &outputGroup{stdout: stdout, stderr: stderr}
+		g.groups[key] = group
+	}
+	group.repos = append(group.repos, r)
+}
+
+// sorted returns the groups, from the largest to the smallest, each with its
+// repos ordered by path. It must be called with g.lock held.
+func (g *outputGroups) sorted() []*outputGroup {
+	var groups []*outputGroup
+	for _, group := range g.groups {
+		sort.Slice(group.repos, func(i, j int) bool {
+			return group.repos[i].dir < group.repos[j].dir
+		})
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if len(groups[i].repos) != len(groups[j].repos) {
+			return len(groups[i].repos) > len(groups[j].repos)
+		}
+		return groups[i].repos[0].dir < groups[j].repos[0].dir
+	})
+	return groups
+}
+
+// header describes the repos of the group, and those that failed.
+func (group *outputGroup) header(name func(repo) string, results *tally) string {
+	var names, failures []string
+	for _, r := range group.repos {
+		names = append(names, name(r))
+		if results.statusOf(r) == failed {
+			failures = append(failures, name(r))
+		}
+	}
+	header := fmt.Sprintf("==> %s (%d repos)", strings.Join(names, ", "), len(names))
+	if len(names) == 1 {
+		header = "==> " + names[0]
+	}
+	if len(failures) > 0 {
+		header += ", failed in " + strings.Join(failures, ", ")
+	}
+	if len(group.stdout) == 0 && len(group.stderr) == 0 {
+		header += ", no output"
+	}
+	return header
+}
+
+// write writes each group of output once, after the repos that wrote it,
+// noting those that failed, from the largest group to the smallest.
+func (g *outputGroups) write(name func(repo) string, results *tally) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	groups := g.sorted()
+	output.Lock()
+	defer output.Unlock()
+	for _, group := range groups {
+		fmt.Println(group.header(name, results))
+		os.Stdout.Write(group.stdout)
+		os.Stderr.Write(group.stderr)
+	}
+}
+
+// writeOutliers writes the repos whose output differs from that of the most
+// repos, and how it differs from it, as lines only the majority wrote, with a
+// -, and lines only they wrote, with a +.
+func (g *outputGroups) writeOutliers(name func(repo) string, results *tally) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	groups := g.sorted()
+	if len(groups) == 0 {
+		return
+	}
+	output.Lock()
+	defer output.Unlock()
+	majority := groups[0]
+	if len(groups) == 1 {
+		fmt.Printf("no outliers, all %d repos wrote the same\n", len(majority.repos))
+		return
+	}
+	total := 0
+	for _, group := range groups {
+		total += len(group.repos)
+	}
+	fmt.Printf("%d of %d repos wrote the same, and these differ from them:\n", len(majority.repos), total)
+	want := string(majority.stdout) + string(majority.stderr)
+	for _, group := range groups[1:] {
+		fmt.Println(group.header(name, results))
+		for _, d := range diff.Do(want, string(group.stdout)+string(group.stderr)) {
+			mark := ""
+			switch d.Type {
+			case diffmatchpatch.DiffDelete:
+				mark = "-"
+			case diffmatchpatch.DiffInsert:
+				mark = "+"
+			default:
+				continue
+			}
+			for _, line := range strings.SplitAfter(strings.TrimSuffix(d.Text, "\n"), "\n") {
+				fmt.Print(mark + strings.TrimSuffix(line, "\n") + "\n")
+			}
+		}
+	}
+}
+
+// stripNotes returns out without the lines git-walk wrote about running in dir,
+// which would otherwise make the output of every repo that failed differ.
+func stripNotes(out []byte, dir string) []byte {
+	note := []byte("cd " + dir + ": ")
+	if !bytes.Contains(out, note) {
+		return out
+	}
+	var kept []byte
+	for _, line := range bytes.SplitAfter(out, []byte("\n")) {
+		if !bytes.HasPrefix(line, note) {
+			kept = append(kept, line...)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+)
+
+// headerData is what the --header and --footer templates are executed with,
+// for the repo being run in. The branch and remote are only read if used.
+type headerData struct {
+	rn *runner
+	r  repo
+
+	Code     int           // The exit code of the command, or -1 if it didn't exit.
+	Duration time.Duration // How long running in the repo took.
+	Status   string        // How running in the repo went.
+}
+
+// Name returns how the repo is referred to in output.
+func (h headerData) Name() string { return h.rn.display(h.r) }
+
+// Path returns the repo's absolute path.
+func (h headerData) Path() string {
+	if abs, err := filepath.Abs(h.r.dir); err == nil {
+		return abs
+	}
+	return h.r.dir
+}
+
+// Dir returns the repo's path, as it was found.
+func (h headerData) Dir() string { return h.r.dir }
+
+// Root returns where the repo was found.
+func (h headerData) Root() string { return h.rn.rootOf(h.r) }
+
+// Branch returns the repo's current branch.
+func (h headerData) Branch() string { return currentBranch(h.r.dir) }
+
+// Remote returns the repo's origin URL.
+func (h headerData) Remote() string { return originURL(h.r.dir) }
+
+// Command returns the commands run in the repo, as lines of shell.
+func (h headerData) Command() string { return h.rn.commandLine(h.r) }
+
+// commandLine returns the commands run in r, as a line of shell.
+func (rn *runner) commandLine(r repo) string {
+	var cmds []string
+	for _, cmd := range rn.commandsOf(r) {
+		cmds = append(cmds, strings.Join(expand(cmd, r, rn.here), " "))
+	}
+	return strings.Join(cmds, "; ")
+}
+
+// Index returns the order in which the repo was found, from 1.
+func (h headerData) Index() int { return h.r.seq + 1 }
+
+// Total returns how many repos were found, or 0 if not all have been yet.
+func (h headerData) Total() int { return int(atomic.LoadInt32(&h.rn.total)) }
+
+// parseHeader parses the template of a --header or --footer.
+func parseHeader(name, text string) (*template.Template, error) {
+	return template.New(name).Parse(text)
+}
+
+// heading returns t executed with h, as a line, or if that fails, notes why
+// in stderr.
+func heading(t *template.Template, h headerData, stderr io.Writer) string {
+	var b bytes.Buffer
+	if err := t.Execute(&b, h); err != nil {
+		fmt.Fprintf(stderr, "cd %s: --%s failed with %v\n", h.rn.path(h.r), t.Name(), err)
+		return ""
+	}
+	if b.Len() > 0 && !bytes.HasSuffix(b.Bytes(), []byte("\n")) {
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// writeHeader writes the header for r directly, before running in it, when
+// its output isn't captured, and returns whether it did.
+func (rn *runner) writeHeader(r repo, stderr io.Writer) bool {
+	if rn.header == nil || !rn.direct && !rn.stream {
+		return false
+	}
+	head := heading(rn.header, headerData{rn: rn, r: r}, stderr)
+	if rn.stream && head != "" {
+		prefix := rn.display(r) + " | "
+		head = prefix + strings.ReplaceAll(strings.TrimSuffix(head, "\n"), "\n", "\n"+prefix) + "\n"
+	}
+	output.Lock()
+	clearProgress()
+	fmt.Print(head)
+	output.Unlock()
+	return true
+}
+
+// frame returns stdout, the output of running in r, after its header, unless
+// that was already written, and before its footer, unless it should go
+// unmentioned, because it wrote nothing.
+func (rn *runner) frame(r repo, headed bool, st status, code int, took time.Duration, stdout, stderr *spool) *spool {
+	if rn.header == nil && rn.footer == nil {
+		return stdout
+	}
+	if rn.skipEmpty && st == succeeded && stdout.Len() == 0 && stderr.Len() == 0 {
+		return stdout
+	}
+	h := headerData{rn: rn, r: r}
+	framed := new(spool)
+	if rn.header != nil && !headed {
+		framed.WriteString(heading(rn.header, h, stderr))
+	}
+	stdout.WriteTo(framed)
+	stdout.Close()
+	if rn.footer != nil {
+		h.Code, h.Duration, h.Status = code, took.Round(time.Millisecond), st.String()
+		framed.WriteString(heading(rn.footer, h, stderr))
+	}
+	return framed
+}
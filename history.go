@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// historyKeep is how many runs are kept in the history, older ones being
+// removed as new ones are saved.
+const historyKeep = 100
+
+// A pastRun is a run of commands saved in the history, and how running in
+// each repo went.
+type pastRun struct {
+	ID      int        `json:"id"`
+	Command string     `json:"command"`
+	Where   []string   `json:"where"`
+	Started time.Time  `json:"started"`
+	Took    float64    `json:"took"` // In seconds.
+	Repos   []pastRepo `json:"repos"`
+}
+
+// A pastRepo is how running in a repo went, in a past run.
+type pastRepo struct {
+	Repo   string  `json:"repo"` // Its absolute path.
+	Status string  `json:"status"`
+	Took   float64 `json:"took,omitempty"` // In seconds.
+}
+
+// count returns how many repos had status st.
+func (pr *pastRun) count(st status) int {
+	n := 0
+	for _, r := range pr.Repos {
+		if r.Status == st.String() {
+			n++
+		}
+	}
+	return n
+}
+
+// failures returns the repos that failed.
+func (pr *pastRun) failures() map[string]bool {
+	bad := map[string]bool{}
+	for _, r := range pr.Repos {
+		if r.Status == failed.String() {
+			bad[r.Repo] = true
+		}
+	}
+	return bad
+}
+
+// historyDir returns the directory the history is kept in, in the user's
+// cache directory.
+func historyDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "git-walk", "history"), nil
+}
+
+// historyIDs returns the IDs of the runs in the history, in order.
+func historyIDs(dir string) ([]int, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var ids []int
+	for _, e := range entries {
+		if id, err := strconv.Atoi(strings.TrimSuffix(e.Name(), ".json")); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+// newPastRun returns the run of rn in repos, as it went, to be saved.
+func newPastRun(rn *runner, repos []repo, results *tally, start time.Time) *pastRun {
+	var cmds []string
+	for _, cmd := range rn.cmds {
+		cmds = append(cmds, strings.Join(cmd, " "))
+	}
+	pr := &pastRun{
+		Command: strings.Join(cmds, "; "),
+		Where:   rn.roots,
+		Started: start,
+		Took:    time.Since(start).Seconds(),
+	}
+	for _, r := range repos {
+		pr.Repos = append(pr.Repos, pastRepo{
+			Repo:   absDir(r.dir),
+			Status: results.statusOf(r).String(),
+			Took:   results.tookIn(r).Seconds(),
+		})
+	}
+	return pr
+}
+
+// saveRun saves pr in the history, as the run after the last one saved, and
+// removes the oldest runs beyond historyKeep.
+func saveRun(pr *pastRun) error {
+	dir, err := historyDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	ids, err := historyIDs(dir)
+	if err != nil {
+		return err
+	}
+	pr.ID = 1
+	if len(ids) > 0 {
+		pr.ID = ids[len(ids)-1] + 1
+	}
+	data, err := json.MarshalIndent(pr, "", "  ")
+	if err != nil {
+		return err
+	}
+	for {
+		// Another git-walk may be saving a run of its own.
+		f, err := os.OpenFile(filepath.Join(dir, strconv.Itoa(pr.ID)+".json"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if errors.Is(err, os.ErrExist) {
+			pr.ID++
+			continue
+		} else if err != nil {
+			return err
+		}
+		if _, err := f.Write(append(data, '\n')); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		break
+	}
+	ids = append(ids, pr.ID)
+	for len(ids) > historyKeep {
+		os.Remove(filepath.Join(dir, strconv.Itoa(ids[0])+".json"))
+		ids = ids[1:]
+	}
+	return nil
+}
+
+// loadRun returns the run in the history with the ID id.
+func loadRun(id string) (*pastRun, error) {
+	dir, err := historyDir()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := strconv.Atoi(id); err != nil {
+		return nil, fmt.Errorf("%q is not the ID of a run", id)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, id+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no run %s in the history", id)
+	} else if err != nil {
+		return nil, err
+	}
+	pr := new(pastRun)
+	return pr, json.Unmarshal(data, pr)
+}
+
+// writeHistory writes a line about each of the last n runs in the history.
+func writeHistory(w io.Writer, n int) error {
+	dir, err := historyDir()
+	if err != nil {
+		return err
+	}
+	ids, err := historyIDs(dir)
+	if err != nil {
+		return err
+	}
+	if n > 0 && len(ids) > n {
+		ids = ids[len(ids)-n:]
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "ID\tSTARTED\tTOOK\tREPOS\tFAILED\tCOMMAND\n")
+	for _, id := range ids {
+		pr, err := loadRun(strconv.Itoa(id))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%v\t%d\t%d\t%s\n", pr.ID, pr.Started.Local().Format("2006-01-02 15:04"),
+			seconds(pr.Took), len(pr.Repos), pr.count(failed), pr.Command)
+	}
+	return tw.Flush()
+}
+
+// seconds returns s seconds, as a duration.
+func seconds(s float64) time.Duration {
+	return (time.Duration(s*1000) * time.Millisecond).Round(time.Millisecond)
+}
+
+// show writes how running in each repo of the run went, ordered by path.
+func (pr *pastRun) show(w io.Writer) error {
+	fmt.Fprintf(w, "run %d: %s\n", pr.ID, pr.Command)
+	fmt.Fprintf(w, "in %s, at %s, for %v\n", strings.Join(pr.Where, ", "),
+		pr.Started.Local().Format("2006-01-02 15:04:05"), seconds(pr.Took))
+	fmt.Fprintf(w, "%d repos: %d succeeded, %d failed, %d skipped, %d not processed\n", len(pr.Repos),
+		pr.count(succeeded), pr.count(failed), pr.count(skipped), pr.count(pending))
+	sort.SliceStable(pr.Repos, func(i, j int) bool { return pr.Repos[i].Repo < pr.Repos[j].Repo })
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, r := range pr.Repos {
+		fmt.Fprintf(tw, "  %s\t%s\t%v\n", r.Status, r.Repo, seconds(r.Took))
+	}
+	return tw.Flush()
+}
+
+// diffRuns writes the repos that failed in one of the runs, but not the other.
+func diffRuns(w io.Writer, before, after *pastRun) {
+	list := func(in, notIn *pastRun) {
+		bad, ok := in.failures(), notIn.failures()
+		var repos []string
+		for r := range bad {
+			if !ok[r] {
+				repos = append(repos, r)
+			}
+		}
+		sort.Strings(repos)
+		fmt.Fprintf(w, "%d failed in run %d, but not in run %d\n", len(repos), in.ID, notIn.ID)
+		for _, r := range repos {
+			fmt.Fprintf(w, "  %s\n", r)
+		}
+	}
+	list(after, before)
+	list(before, after)
+}
+
+// runHistory runs the history command, with its args.
+func runHistory(w io.Writer, args []string, last int) error {
+	switch {
+	case len(args) == 0:
+		return writeHistory(w, last)
+	case args[0] == "show" && len(args) == 2:
+		pr, err := loadRun(args[1])
+		if err != nil {
+			return err
+		}
+		return pr.show(w)
+	case args[0] == "diff" && len(args) == 3:
+		before, err := loadRun(args[1])
+		if err != nil {
+			return err
+		}
+		after, err := loadRun(args[2])
+		if err != nil {
+			return err
+		}
+		diffRuns(w, before, after)
+		return nil
+	}
+	return fmt.Errorf("history: expected nothing, show ID, or diff ID ID, not %q", args)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// runHook runs the --which shell script in r, with the repo's environment and
+// env, writing its output as the commands' is written.
+func (rn *runner) runHook(r repo, which, script string, env []string, stdout, stderr io.Writer) error {
+	hook := shellCommand(script)
+	child := exec.Command(hook[0], hook[1:]...)
+	setCommandLine(child, hook)
+	child.Dir = r.dir
+	child.Env = append(append(os.Environ(), rn.env(r)...), env...)
+	out, errOut := stdout, stderr
+	switch {
+	case rn.direct:
+		out, errOut = os.Stdout, os.Stderr
+	case rn.stream:
+		prefix := rn.display(r) + " | "
+		stdout := &prefixWriter{w: os.Stdout, prefix: prefix}
+		stderr := &prefixWriter{w: os.Stderr, prefix: prefix}
+		defer stdout.Flush()
+		defer stderr.Flush()
+		out, errOut = stdout, stderr
+	}
+	child.Stdout, child.Stderr = rn.strip(out), rn.strip(errOut)
+	err := rn.run(child)
+	if err != nil && err != errCanceled {
+		fmt.Fprint(stderr, paint(rn.colorErr, red, fmt.Sprintf("cd %s: --%s failed on %v%s\n",
+			rn.path(r), which, err, label(r))))
+	}
+	return err
+}
+
+// runAllHook runs the --which shell script where git-walk was run, before or
+// after running in every repo, with env, and the roots in $GIT_WALK_WHERE.
+func runAllHook(which, script string, roots []string, env []string) error {
+	var where []string
+	for _, root := range roots {
+		where = append(where, absDir(root))
+	}
+	hook := shellCommand(script)
+	child := exec.Command(hook[0], hook[1:]...)
+	setCommandLine(child, hook)
+	child.Env = append(os.Environ(), "GIT_WALK_WHERE="+strings.Join(where, string(filepath.ListSeparator)))
+	child.Env = append(child.Env, env...)
+	child.Stdin, child.Stdout, child.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := child.Run(); err != nil {
+		return fmt.Errorf("--%s failed on %v", which, err)
+	}
+	return nil
+}
+
+// allHookEnv returns the environment of the --after-all hook: how many repos
+// running in went each way, and how the run as a whole went.
+func allHookEnv(results *tally, repos []repo, interrupted bool) []string {
+	ok := len(results.with(repos, succeeded))
+	bad := len(results.with(repos, failed))
+	skip := len(results.with(repos, skipped))
+	st := succeeded.String()
+	switch {
+	case interrupted:
+		st = "interrupted"
+	case bad > 0:
+		st = failed.String()
+	}
+	return []string{
+		fmt.Sprintf("GIT_WALK_TOTAL=%d", len(repos)),
+		fmt.Sprintf("GIT_WALK_SUCCEEDED=%d", ok),
+		fmt.Sprintf("GIT_WALK_FAILED=%d", bad),
+		fmt.Sprintf("GIT_WALK_SKIPPED=%d", skip),
+		"GIT_WALK_STATUS=" + st,
+	}
+}
@@ -0,0 +1,16 @@
+package main
+
+import "syscall"
+
+// ioniceSupported is whether commands can be run with an I/O priority.
+const ioniceSupported = true
+
+// setIOPriority sets the I/O priority of process pid, as ioprio_set(2) does.
+func setIOPriority(pid, prio int) error {
+	const ioprioWhoProcess = 1
+	_, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(pid), uintptr(prio))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// ioniceSupported is whether commands can be run with an I/O priority.
+const ioniceSupported = false
+
+func setIOPriority(pid, prio int) error {
+	return errors.New("I/O priorities are only supported on Linux")
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// A jobEntry is a line of a job log, noting that running in a repo started,
+// or how it ended.
+type jobEntry struct {
+	Repo    string `json:"repo"`    // The repo's absolute path.
+	Command string `json:"command"` // The commands run, as a line of shell.
+	Status  string `json:"status"`  // "started", or how it ended.
+	Time    string `json:"time"`
+}
+
+// A jobLog records, a line of JSON at a time, when running in each repo
+// started, and how it ended, so a run can be picked up where it stopped.
+type jobLog struct {
+	lock sync.Mutex
+	f    *os.File
+	enc  *json.Encoder
+	err  error // The first write that failed.
+}
+
+// openJobLog opens the job log at path, appending to it if it exists.
+func openJobLog(path string) (*jobLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	return &jobLog{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+// absDir returns the absolute path of dir, or dir, if it has none.
+func absDir(dir string) string {
+	if abs, err := filepath.Abs(dir); err == nil {
+		return abs
+	}
+	return dir
+}
+
+// started notes that running in r started.
+func (jl *jobLog) started(rn *runner, r repo) {
+	jl.write(rn, r, "started")
+}
+
+// ended notes how running in r ended.
+func (jl *jobLog) ended(rn *runner, r repo, st status) {
+	jl.write(rn, r, st.String())
+}
+
+func (jl *jobLog) write(rn *runner, r repo, what string) {
+	jl.lock.Lock()
+	defer jl.lock.Unlock()
+	err := jl.enc.Encode(jobEntry{
+		Repo:    absDir(r.dir),
+		Command: rn.commandLine(r),
+		Status:  what,
+		Time:    time.Now().Format(time.RFC3339),
+	})
+	if err != nil && jl.err == nil {
+		jl.err = err
+	}
+}
+
+// close closes the log, returning the first error writing it.
+func (jl *jobLog) close() error {
+	jl.lock.Lock()
+	defer jl.lock.Unlock()
+	if err := jl.f.Close(); err != nil && jl.err == nil {
+		jl.err = err
+	}
+	return jl.err
+}
+
+// readJobLog returns how running in each repo in the job log at path last
+// ended, by the repo's absolute path, as "started" if it never did.
+func readJobLog(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	ended := map[string]string{}
+	scan := bufio.NewScanner(f)
+	for n := 1; scan.Scan(); n++ {
+		var e jobEntry
+		if err := json.Unmarshal(scan.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+		ended[e.Repo] = e.Status
+	}
+	return ended, scan.Err()
+}
+
+// resumeFilter selects the repos the job log didn't record as having
+// succeeded, or if retrying, only those it recorded as not having.
+func resumeFilter(ended map[string]string, retrying bool) filter {
+	return func(dir string) bool {
+		st, ok := ended[absDir(dir)]
+		switch {
+		case st == succeeded.String():
+			slog.Debug("skip", "repo", dir, "reason", "already succeeded")
+			return false
+		case retrying && !ok:
+			slog.Debug("skip", "repo", dir, "reason", "not in the job log")
+			return false
+		}
+		return true
+	}
+}
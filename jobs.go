@@ -0,0 +1,86 @@
+package main
+
+import (
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// networkJobs is how many commands -n auto runs at once, when they mostly wait
+// on the network, rather than use the CPU.
+const networkJobs = 32
+
+// networkCommand matches command lines that talk to remotes.
+var networkCommand = regexp.MustCompile(`\bgit\b.*\b(fetch|pull|push|clone|ls-remote|remote update)\b`)
+
+// rateLimited matches the errors of commands refused by a busy remote.
+var rateLimited = regexp.MustCompile(`(?i)rate.?limit|too many requests|\b429\b`)
+
+// autoJobs returns how many commands to run at once, for -n auto: one per CPU,
+// unless they mostly wait on the network.
+func autoJobs(name string, cmds [][]string) int {
+	switch name {
+	case "fetch", "clone", "sync":
+		return networkJobs
+	case "maintenance":
+		return maintenanceJobs
+	}
+	for _, cmd := range cmds {
+		if networkCommand.MatchString(strings.Join(cmd, " ")) {
+			return networkJobs
+		}
+	}
+	return runtime.NumCPU()
+}
+
+// A jobLimit limits how many repos are run in at once, to a limit that can be
+// lowered as they run. A nil *jobLimit doesn't limit them.
+type jobLimit struct {
+	lock    sync.Mutex
+	cond    *sync.Cond
+	limit   int
+	running int
+}
+
+func newJobLimit(limit int) *jobLimit {
+	j := &jobLimit{limit: limit}
+	j.cond = sync.NewCond(&j.lock)
+	return j
+}
+
+// start waits until another repo can be run in.
+func (j *jobLimit) start() {
+	if j == nil {
+		return
+	}
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	for j.running >= j.limit {
+		j.cond.Wait()
+	}
+	j.running++
+}
+
+// done records that a repo is no longer being run in.
+func (j *jobLimit) done() {
+	if j == nil {
+		return
+	}
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.running--
+	j.cond.Signal()
+}
+
+// lower halves the limit, down to one at a time, and returns it, and whether
+// it was lowered.
+func (j *jobLimit) lower() (int, bool) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if j.limit == 1 {
+		return 1, false
+	}
+	j.limit /= 2
+	return j.limit, true
+}
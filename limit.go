@@ -0,0 +1,54 @@
+package main
+
+import "sync"
+
+// A hostLimiter limits how many commands run at once in repos with remotes
+// on the same host. Repos that can't run yet wait in a queue for their host,
+// and are run as soon as another command for the same host completes,
+// leaving workers free to run repos of other hosts meanwhile.
+type hostLimiter struct {
+	limit   int
+	lock    sync.Mutex
+	running map[string]int
+	waiting map[string][]repo
+}
+
+func newHostLimiter(limit int) *hostLimiter {
+	return &hostLimiter{
+		limit:   limit,
+		running: make(map[string]int),
+		waiting: make(map[string][]repo),
+	}
+}
+
+// start reports whether r, with a remote on host, may run now. If not, r is
+// queued until a slot for host is done.
+func (h *hostLimiter) start(host string, r repo) bool {
+	if host == "" {
+		return true
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.running[host] < h.limit {
+		h.running[host]++
+		return true
+	}
+	h.waiting[host] = append(h.waiting[host], r)
+	return false
+}
+
+// done releases a slot for host. If a repo is waiting for it, the slot is
+// passed on, and the repo is returned to be run.
+func (h *hostLimiter) done(host string) (repo, bool) {
+	if host == "" {
+		return repo{}, false
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if q := h.waiting[host]; len(q) > 0 {
+		h.waiting[host] = q[1:]
+		return q[0], true
+	}
+	h.running[host]--
+	return repo{}, false
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// lockPath returns the path of the lock held while commands are run in the
+// repos of root.
+func lockPath(root string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(abs))
+	return filepath.Join(dir, "git-walk", fmt.Sprintf("%x.lock", sum[:16])), nil
+}
+
+// heldLocks are the files locked by lockRoot, kept so they aren't closed by
+// the garbage collector.
+var heldLocks []*os.File
+
+// lockRoot takes the advisory lock on runs in root, so runs in the same root
+// don't overlap, and holds it until git-walk exits. If another run holds it,
+// lockRoot fails, or with wait, waits for the lock until ctx is done.
+func lockRoot(ctx context.Context, root string, wait bool) error {
+	path, err := lockPath(root)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	waiting := false
+	for {
+		locked, err := tryLock(f)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		if locked {
+			break
+		}
+		holder := lockHolder(path)
+		if !wait {
+			f.Close()
+			return fmt.Errorf("git-walk is already running in %s%s, use --wait to wait for it, or --no-lock", root, holder)
+		}
+		if !waiting {
+			fmt.Fprintf(os.Stderr, "waiting for git-walk running in %s%s\n", root, holder)
+			waiting = true
+		}
+		select {
+		case <-time.After(250 * time.Millisecond):
+		case <-ctx.Done():
+			f.Close()
+			return ctx.Err()
+		}
+	}
+	// Say who holds the lock, for those waiting for it.
+	f.Truncate(0)
+	fmt.Fprintf(f, "%d %s\n", os.Getpid(), strings.Join(os.Args, " "))
+	// The file is left open, so the lock is held until git-walk exits.
+	heldLocks = append(heldLocks, f)
+	return nil
+}
+
+// lockRoots takes the locks of each of roots, in the order of their lock
+// files, so runs given some of the same roots can't each wait for the other.
+// The locks are of each exact root, so runs in a root, and in a directory
+// below it, don't conflict.
+func lockRoots(ctx context.Context, roots []string, wait bool) error {
+	paths := map[string]string{}
+	var sorted []string
+	for _, root := range roots {
+		path, err := lockPath(root)
+		if err != nil {
+			return err
+		}
+		paths[root] = path
+		sorted = append(sorted, root)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return paths[sorted[i]] < paths[sorted[j]] })
+	for _, root := range sorted {
+		if err := lockRoot(ctx, root, wait); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lockHolder describes the git-walk holding the lock at path, if known.
+func lockHolder(path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	pid, args, _ := strings.Cut(strings.TrimSpace(string(data)), " ")
+	if pid == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (pid %s: %s)", pid, args)
+}
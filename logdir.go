@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// logPath returns the path of r's log, below the log dir.
+func (rn *runner) logPath(r repo) string {
+	return rn.fileOf(rn.logDir, r, ".log")
+}
+
+// fileOf returns the path of a file of r, below dir, at the repo's path
+// relative to the root, or for repos not below the root, at their absolute
+// path, with ext after it.
+func (rn *runner) fileOf(dir string, r repo, ext string) string {
+	name := rn.name(r)
+	if filepath.IsAbs(name) {
+		name = strings.TrimPrefix(name, filepath.VolumeName(name))
+	}
+	return filepath.Join(dir, name+ext)
+}
+
+// writeLog writes the output of running in r to its log, after a header of
+// the commands run, when, for how long, and how they exited.
+func (rn *runner) writeLog(r repo, start time.Time, code int, took time.Duration, stdout, stderr *spool) error {
+	path := rn.logPath(r)
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(f, "# repo: %s\n", r.dir)
+	for _, cmd := range rn.commandsOf(r) {
+		fmt.Fprintf(f, "# command: %s\n", strings.Join(expand(cmd, r, rn.here), " "))
+	}
+	fmt.Fprintf(f, "# started: %s\n", start.Format(time.RFC3339))
+	fmt.Fprintf(f, "# took: %v\n", took.Round(time.Millisecond))
+	if code < 0 {
+		fmt.Fprintf(f, "# exit: none\n")
+	} else {
+		fmt.Fprintf(f, "# exit: %d\n", code)
+	}
+	fmt.Fprintf(f, "\n--- stdout\n")
+	stdout.WriteTo(f)
+	fmt.Fprintf(f, "\n--- stderr\n")
+	stderr.WriteTo(f)
+	return f.Close()
+}
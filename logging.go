@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// logLevels are the levels of --log-level, from the most said to the least.
+var logLevels = []string{"debug", "info", "warn", "error"}
+
+// newLogger returns a logger writing to w, in the format of --log-format,
+// what is at least as important as level.
+func newLogger(w io.Writer, level, format string) (*slog.Logger, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("bad --log-level %q", level)
+	}
+	opts := &slog.HandlerOptions{Level: l}
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("bad --log-format %q", format)
+}
+
+// because returns a filter selecting what f does, logging reason as why the
+// repos it doesn't select were skipped.
+func because(reason string, f filter) filter {
+	return func(dir string) bool {
+		if !f(dir) {
+			slog.Debug("skip", "repo", dir, "reason", reason)
+			return false
+		}
+		return true
+	}
+}
+
+// logStarted logs child having been started, and returns a func logging
+// how it exited, and returning err, which it exited with.
+func logStarted(child *exec.Cmd) func(err error) error {
+	start := time.Now()
+	pid := child.Process.Pid
+	slog.Debug("started", "dir", child.Dir, "pid", pid, "command", strings.Join(child.Args, " "))
+	return func(err error) error {
+		attrs := []any{"pid", pid, "code", exitCode(err), "took", time.Since(start).Round(time.Millisecond)}
+		if err != nil {
+			attrs = append(attrs, "err", err)
+		}
+		slog.Debug("exited", attrs...)
+		return err
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// maintenanceJobs is how many repos maintenance runs in at once, unless told
+// otherwise, since gc is heavy on IO.
+const maintenanceJobs = 4
+
+// A maintainer runs git's housekeeping in repos, noting when it last did, so
+// repos maintained recently can be skipped.
+type maintainer struct {
+	gc        bool   // Run git gc --auto, rather than git maintenance run --auto.
+	marks     string // The directory of the marks of when repos were maintained.
+	reclaimed int64  // Bytes reclaimed in all, accessed atomically.
+}
+
+func newMaintainer(gc bool) (*maintainer, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	return &maintainer{gc: gc, marks: filepath.Join(dir, "git-walk", "maintained")}, nil
+}
+
+// command returns the git command run in each repo.
+func (m *maintainer) command() []string {
+	if m.gc {
+		return []string{"git", "gc", "--auto"}
+	}
+	return []string{"git", "maintenance", "run", "--auto"}
+}
+
+// markOf returns the path of the mark of when the repo at dir was maintained.
+func (m *maintainer) markOf(dir string) string {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		abs = dir
+	}
+	sum := sha256.Sum256([]byte(abs))
+	return filepath.Join(m.marks, fmt.Sprintf("%x", sum[:16]))
+}
+
+// due selects repos that weren't maintained in the last since.
+func (m *maintainer) due(since time.Duration) filter {
+	return func(dir string) bool {
+		info, err := os.Stat(m.markOf(dir))
+		if err != nil {
+			return true
+		}
+		if ago := time.Since(info.ModTime()); ago < since {
+			slog.Debug("skip", "repo", dir, "reason", "maintained", "ago", ago.Round(time.Second))
+			return false
+		}
+		return true
+	}
+}
+
+// maintain runs git's housekeeping in r, and writes how much space it
+// reclaimed to stdout.
+func (m *maintainer) maintain(ctx context.Context, r repo, stdout, stderr io.Writer) error {
+	gitDir, err := gitOutput(r.dir, "rev-parse", "--git-common-dir")
+	if err != nil {
+		return fmt.Errorf("rev-parse failed with %v", err)
+	}
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(r.dir, gitDir)
+	}
+	before := diskUsage(gitDir)
+	// It isn't left to run in the background, so what it reclaims is known.
+	args := append([]string{"-c", "gc.autoDetach=false", "-c", "maintenance.autoDetach=false"}, m.command()[1:]...)
+	git := commandContext(ctx, "git", args...)
+	git.Dir = r.dir
+	git.Stdout = stdout
+	git.Stderr = stderr
+	if err := runGroup(git); err != nil {
+		return err
+	}
+	after := diskUsage(gitDir)
+	if before > after {
+		atomic.AddInt64(&m.reclaimed, before-after)
+		fmt.Fprintf(stdout, "reclaimed %s, from %s to %s\n", humanSize(before-after), humanSize(before), humanSize(after))
+	}
+	if err := m.mark(r.dir); err != nil {
+		fmt.Fprintf(stderr, "mark %q failed with %v\n", r.dir, err)
+	}
+	return nil
+}
+
+// mark notes that the repo at dir was just maintained.
+func (m *maintainer) mark(dir string) error {
+	if err := os.MkdirAll(m.marks, 0755); err != nil {
+		return err
+	}
+	path := m.markOf(dir)
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err == nil {
+		return nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// total returns how much space was reclaimed in all.
+func (m *maintainer) total() int64 {
+	return atomic.LoadInt64(&m.reclaimed)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A manifest describes the layout of the repos in a tree, so it can be
+// reproduced elsewhere.
+type manifest struct {
+	Repos []manifestRepo `json:"repos" yaml:"repos"`
+}
+
+// A manifestRepo is a repo in a manifest.
+type manifestRepo struct {
+	Path    string            `json:"path" yaml:"path"` // Slash-separated, relative to the tree.
+	Remotes map[string]string `json:"remotes,omitempty" yaml:"remotes,omitempty"`
+	Branch  string            `json:"branch,omitempty" yaml:"branch,omitempty"`
+	Head    string            `json:"head,omitempty" yaml:"head,omitempty"`
+}
+
+// manifestRepoOf describes r, found in root, for a manifest.
+func manifestRepoOf(root string, r repo) (manifestRepo, error) {
+	rel, err := filepath.Rel(root, r.dir)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		rel, _ = filepath.Abs(r.dir)
+	}
+	m := manifestRepo{Path: filepath.ToSlash(rel)}
+	g, st := readHead(r)
+	if st.err != nil {
+		return m, st.err
+	}
+	m.Branch = st.branch
+	if !st.head.IsZero() {
+		m.Head = st.head.String()
+	}
+	remotes, err := g.Remotes()
+	if err != nil {
+		return m, err
+	}
+	for _, remote := range remotes {
+		cfg := remote.Config()
+		if len(cfg.URLs) == 0 {
+			continue
+		}
+		if m.Remotes == nil {
+			m.Remotes = map[string]string{}
+		}
+		m.Remotes[cfg.Name] = cfg.URLs[0]
+	}
+	return m, nil
+}
+
+// A manifestWriter collects repos, to write as a manifest.
+type manifestWriter struct {
+	lock  sync.Mutex
+	repos []manifestRepo
+}
+
+func (mw *manifestWriter) add(m manifestRepo) {
+	mw.lock.Lock()
+	defer mw.lock.Unlock()
+	mw.repos = append(mw.repos, m)
+}
+
+// write writes the manifest, with repos ordered by path, as JSON or YAML.
+func (mw *manifestWriter) write(w io.Writer, format string) error {
+	mw.lock.Lock()
+	defer mw.lock.Unlock()
+	sort.Slice(mw.repos, func(i, j int) bool {
+		return mw.repos[i].Path < mw.repos[j].Path
+	})
+	m := manifest{Repos: mw.repos}
+	if format == "yaml" {
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(m); err != nil {
+			return err
+		}
+		return enc.Close()
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(m)
+}
+
+// writeManifest writes the manifest to path, or to stdout if path is "".
+func writeManifest(mw *manifestWriter, path, format string) error {
+	if path == "" {
+		return mw.write(os.Stdout, format)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := mw.write(f, format); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readManifest reads a manifest written as JSON or YAML from path, or from
+// stdin if path is "-".
+func readManifest(path string) (*manifest, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// JSON is YAML, so either can be read as YAML.
+	var m manifest
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
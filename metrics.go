@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Upper bounds of the command duration histogram's buckets, in seconds.
+var durationBuckets = []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300}
+
+// metrics records what a long-running git-walk has found and run in, to be
+// scraped by Prometheus.
+type metrics struct {
+	lock      sync.Mutex
+	repos     int
+	states    map[string]repoStatus // The last status read of each repo, by dir.
+	runs      map[status]int        // Commands run, by how they finished.
+	durations map[string]*histogram // By command.
+}
+
+// A histogram counts observations in durationBuckets.
+type histogram struct {
+	counts []int // Per bucket, not cumulative.
+	sum    float64
+	count  int
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		states:    map[string]repoStatus{},
+		runs:      map[status]int{},
+		durations: map[string]*histogram{},
+	}
+}
+
+// found records the repos found, forgetting the status of those no longer
+// found.
+func (m *metrics) found(repos []repo) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.repos = len(repos)
+	dirs := map[string]bool{}
+	for _, r := range repos {
+		dirs[r.dir] = true
+	}
+	for dir := range m.states {
+		if !dirs[dir] {
+			delete(m.states, dir)
+		}
+	}
+}
+
+// read records the status of a repo.
+func (m *metrics) read(st repoStatus) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.states[st.repo.dir] = st
+}
+
+// ran records that cmd finished with st, after took.
+func (m *metrics) ran(cmd string, st status, took time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.runs[st]++
+	h := m.durations[cmd]
+	if h == nil {
+		h = &histogram{counts: make([]int, len(durationBuckets))}
+		m.durations[cmd] = h
+	}
+	secs := took.Seconds()
+	for i, le := range durationBuckets {
+		if secs <= le {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += secs
+	h.count++
+}
+
+// write writes the metrics in the Prometheus text format.
+func (m *metrics) write(w io.Writer) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var dirty, ahead, behind, diverged, failing int
+	for _, st := range m.states {
+		switch {
+		case st.err != nil:
+			failing++
+			continue
+		case st.dirty:
+			dirty++
+		}
+		if st.ahead > 0 {
+			ahead++
+		}
+		if st.behind > 0 {
+			behind++
+		}
+		if st.ahead > 0 && st.behind > 0 {
+			diverged++
+		}
+	}
+	gauge := func(name, help string, v int) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %d\n", name, help, name, name, v)
+	}
+	gauge("git_walk_repos", "Repos found.", m.repos)
+	gauge("git_walk_repos_read", "Repos whose status has been read.", len(m.states))
+	gauge("git_walk_repos_dirty", "Repos with uncommitted changes.", dirty)
+	gauge("git_walk_repos_ahead", "Repos with commits not pushed to their upstream.", ahead)
+	gauge("git_walk_repos_behind", "Repos behind their upstream.", behind)
+	gauge("git_walk_repos_diverged", "Repos both ahead of and behind their upstream.", diverged)
+	gauge("git_walk_repos_unreadable", "Repos whose status could not be read.", failing)
+
+	fmt.Fprintf(w, "# HELP git_walk_commands_total Commands run, by how they finished.\n")
+	fmt.Fprintf(w, "# TYPE git_walk_commands_total counter\n")
+	for _, st := range []status{succeeded, failed} {
+		fmt.Fprintf(w, "git_walk_commands_total{status=\"%s\"} %d\n", st, m.runs[st])
+	}
+	fmt.Fprintf(w, "git_walk_commands_total{status=\"canceled\"} %d\n", m.runs[pending])
+
+	fmt.Fprintf(w, "# HELP git_walk_command_duration_seconds How long commands took to run in a repo.\n")
+	fmt.Fprintf(w, "# TYPE git_walk_command_duration_seconds histogram\n")
+	var cmds []string
+	for cmd := range m.durations {
+		cmds = append(cmds, cmd)
+	}
+	sort.Strings(cmds)
+	for _, cmd := range cmds {
+		h := m.durations[cmd]
+		label := fmt.Sprintf("command=\"%s\"", labelValue(cmd))
+		total := 0
+		for i, le := range durationBuckets {
+			total += h.counts[i]
+			fmt.Fprintf(w, "git_walk_command_duration_seconds_bucket{%s,le=\"%s\"} %d\n",
+				label, strconv.FormatFloat(le, 'g', -1, 64), total)
+		}
+		fmt.Fprintf(w, "git_walk_command_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", label, h.count)
+		fmt.Fprintf(w, "git_walk_command_duration_seconds_sum{%s} %g\n", label, h.sum)
+		fmt.Fprintf(w, "git_walk_command_duration_seconds_count{%s} %d\n", label, h.count)
+	}
+}
+
+// ServeHTTP serves the metrics, at /metrics.
+func (m *metrics) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.write(w)
+}
+
+// labelValue escapes v to be a label's value.
+func labelValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+// commandName names cmds, in metrics, as the commands run.
+func commandName(cmds [][]string) string {
+	var names []string
+	for _, cmd := range cmds {
+		names = append(names, strings.Join(cmd, " "))
+	}
+	return strings.Join(names, "; ")
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// An mrRepo is a repo listed in a myrepos .mrconfig file.
+type mrRepo struct {
+	dir      string
+	checkout string // The command to check the repo out, if any.
+}
+
+// readMrconfig reads the repos listed in the .mrconfig at path. Sections are
+// repo paths, relative to the file's directory, except for DEFAULT. Values
+// continue onto indented lines, and lines ending with a backslash.
+func readMrconfig(path string) ([]mrRepo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	base := filepath.Dir(path)
+	var repos []mrRepo
+	var cur *mrRepo
+	var key string // The key whose value is being continued, if any.
+	more := false  // Whether the last line ended with a backslash.
+	scan := bufio.NewScanner(f)
+	for scan.Scan() {
+		line := scan.Text()
+		indented := line != "" && (line[0] == ' ' || line[0] == '\t')
+		trimmed := strings.TrimSpace(line)
+		if key != "" && (more || indented && trimmed != "") {
+			more = strings.HasSuffix(trimmed, "\\")
+			if cur != nil && key == "checkout" {
+				cur.checkout += "\n" + strings.TrimSuffix(trimmed, "\\")
+			}
+			continue
+		}
+		key, more = "", false
+		switch {
+		case trimmed == "" || strings.HasPrefix(trimmed, "#"):
+		case strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]"):
+			section := strings.TrimSpace(trimmed[1 : len(trimmed)-1])
+			cur = nil
+			if section == "DEFAULT" {
+				continue
+			}
+			dir := expandHome(section)
+			if !filepath.IsAbs(dir) {
+				dir = filepath.Join(base, dir)
+			}
+			repos = append(repos, mrRepo{dir: filepath.Clean(dir)})
+			cur = &repos[len(repos)-1]
+		default:
+			i := strings.Index(trimmed, "=")
+			if i < 0 {
+				continue
+			}
+			key = strings.TrimSpace(trimmed[:i])
+			value := strings.TrimSpace(trimmed[i+1:])
+			more = strings.HasSuffix(value, "\\")
+			if cur != nil && key == "checkout" {
+				cur.checkout = strings.TrimSuffix(value, "\\")
+			}
+		}
+	}
+	return repos, scan.Err()
+}
+
+// expandHome expands a leading ~ in path to the user's home directory.
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[1:])
+}
+
+// checkoutRepo runs the repo's checkout command with the user's shell, in the
+// directory above the repo, as mr does.
+func (m mrRepo) checkoutRepo() error {
+	output.Lock()
+	defer output.Unlock()
+	parent := filepath.Dir(m.dir)
+	if err := os.MkdirAll(parent, 0777); err != nil {
+		return err
+	}
+	cmd := shellCommand(m.checkout)
+	sh := exec.Command(cmd[0], cmd[1:]...)
+	sh.Dir = parent
+	sh.Stdout = os.Stdout
+	sh.Stderr = os.Stderr
+	return sh.Run()
+}
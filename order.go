@@ -0,0 +1,85 @@
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+// An order buffers the output of each repo, so it can be written in the order
+// of the repos' paths, rather than the order in which commands completed.
+// Output is written as soon as all the repos before it are done, but nothing
+// can be written until all the repos are known.
+type order struct {
+	lock    sync.Mutex
+	outputs map[int]*block // Output of each repo, by seq, until written.
+	order   []int          // Seq of each repo, in output order.
+	next    int            // Index in order of the next repo to write.
+}
+
+type block struct {
+	stdout, stderr *spool
+}
+
+func newOrder() *order {
+	return &order{outputs: make(map[int]*block)}
+}
+
+// done records the output of running in the repo with seq.
+func (o *order) done(seq int, stdout, stderr *spool) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.outputs[seq] = &block{stdout, stderr}
+	o.flush()
+}
+
+// sorted sets the output order to be that of repos, sorted by less, once all
+// the repos are known.
+func (o *order) sorted(repos []repo, less func(a, b repo) bool) {
+	sort.SliceStable(repos, func(i, j int) bool {
+		return less(repos[i], repos[j])
+	})
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.order = make([]int, len(repos))
+	for i, r := range repos {
+		o.order[i] = r.seq
+	}
+	o.flush()
+}
+
+// flush writes output that is next in order. It must be called with o.lock
+// held.
+func (o *order) flush() {
+	for o.next < len(o.order) {
+		b := o.outputs[o.order[o.next]]
+		if b == nil {
+			return
+		}
+		output.Lock()
+		writeSpools(b.stdout, b.stderr)
+		output.Unlock()
+		delete(o.outputs, o.order[o.next])
+		o.next++
+	}
+}
+
+// statusRanks order repos with each status, for --sort status: failures first.
+var statusRanks = map[status]int{failed: 0, pending: 1, succeeded: 2, skipped: 3}
+
+// repoOrder returns how repos are ordered by --sort: by their paths, slowest
+// first, or failures first, and otherwise by their paths.
+func repoOrder(by string, results *tally) func(a, b repo) bool {
+	return func(a, b repo) bool {
+		switch by {
+		case "duration":
+			if ta, tb := results.tookIn(a), results.tookIn(b); ta != tb {
+				return ta > tb
+			}
+		case "status":
+			if ra, rb := statusRanks[results.statusOf(a)], statusRanks[results.statusOf(b)]; ra != rb {
+				return ra < rb
+			}
+		}
+		return a.dir < b.dir
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+var errPickCanceled = errors.New("canceled")
+
+// fuzzyScore reports whether the letters of query appear in name, in order,
+// and if so how spread out they are, so tighter matches can be listed first.
+func fuzzyScore(name, query string) (int, bool) {
+	name, query = strings.ToLower(name), strings.ToLower(query)
+	first, last := -1, -1
+	i := 0
+	for _, q := range query {
+		j := strings.IndexRune(name[i:], q)
+		if j < 0 {
+			return 0, false
+		}
+		if first < 0 {
+			first = i + j
+		}
+		last = i + j
+		i += j + utf8.RuneLen(q)
+	}
+	return last - first, true
+}
+
+// A picker lets the user choose, from a fuzzy-searchable list, which repos to
+// run in.
+type picker struct {
+	repos   []repo
+	names   []string
+	chosen  map[int]bool // By index into repos.
+	query   string
+	matches []int // Indexes of the repos matching the query, best first.
+	cursor  int   // Index into matches.
+	top     int   // The first match shown.
+}
+
+// filter lists the repos matching the query, from the best match.
+func (p *picker) filter() {
+	type match struct{ i, score int }
+	var ms []match
+	for i, name := range p.names {
+		if score, ok := fuzzyScore(name, p.query); ok {
+			ms = append(ms, match{i, score})
+		}
+	}
+	sort.SliceStable(ms, func(a, b int) bool { return ms[a].score < ms[b].score })
+	p.matches = p.matches[:0]
+	for _, m := range ms {
+		p.matches = append(p.matches, m.i)
+	}
+	p.cursor, p.top = 0, 0
+}
+
+// pickRepos asks the user to choose from repos, on the terminal, and returns
+// those chosen, in the order they were found.
+func pickRepos(repos []repo, name func(repo) string) ([]repo, error) {
+	tty, err := openTerminal()
+	if err != nil {
+		return nil, err
+	}
+	defer tty.Close()
+	fmt.Fprint(tty, "\x1b[?1049h")
+	defer fmt.Fprint(tty, "\x1b[?1049l")
+
+	p := &picker{repos: repos, chosen: map[int]bool{}}
+	for _, r := range repos {
+		p.names = append(p.names, name(r))
+	}
+	p.filter()
+
+	keys := make(chan string)
+	go readKeys(tty, keys)
+	for {
+		width, height := tty.size()
+		p.draw(tty, width, height)
+		key, ok := <-keys
+		if !ok {
+			return nil, errPickCanceled
+		}
+		switch key {
+		case "esc", "ctrl+c":
+			return nil, errPickCanceled
+		case "enter":
+			if len(p.chosen) == 0 && len(p.matches) > 0 {
+				p.chosen[p.matches[p.cursor]] = true
+			}
+			var picked []repo
+			for i, r := range repos {
+				if p.chosen[i] {
+					picked = append(picked, r)
+				}
+			}
+			return picked, nil
+		case "up", "\x10": // ^P
+			p.cursor = clamp(p.cursor-1, len(p.matches)-1)
+		case "down", "\x0e": // ^N
+			p.cursor = clamp(p.cursor+1, len(p.matches)-1)
+		case "\t":
+			if len(p.matches) > 0 {
+				i := p.matches[p.cursor]
+				if p.chosen[i] {
+					delete(p.chosen, i)
+				} else {
+					p.chosen[i] = true
+				}
+				p.cursor = clamp(p.cursor+1, len(p.matches)-1)
+			}
+		case "\x01": // ^A toggles all of the matches.
+			all := true
+			for _, i := range p.matches {
+				all = all && p.chosen[i]
+			}
+			for _, i := range p.matches {
+				if all {
+					delete(p.chosen, i)
+				} else {
+					p.chosen[i] = true
+				}
+			}
+		case "\x7f", "\x08":
+			if p.query != "" {
+				_, n := utf8.DecodeLastRuneInString(p.query)
+				p.query = p.query[:len(p.query)-n]
+				p.filter()
+			}
+		case "\x15": // ^U
+			p.query = ""
+			p.filter()
+		default:
+			if len(key) == 1 && key[0] >= ' ' || utf8.RuneCountInString(key) == 1 && key[0] >= 0x80 {
+				p.query += key
+				p.filter()
+			}
+		}
+	}
+}
+
+func (p *picker) draw(tty *terminal, width, height int) {
+	page := height - 2
+	if page < 1 {
+		page = 1
+	}
+	if p.cursor < p.top {
+		p.top = p.cursor
+	}
+	if p.cursor >= p.top+page {
+		p.top = p.cursor - page + 1
+	}
+	lines := []string{"> " + p.query}
+	for i := p.top; i < len(p.matches) && i < p.top+page; i++ {
+		mark, box := "  ", "[ ]"
+		if i == p.cursor {
+			mark = "> "
+		}
+		if p.chosen[p.matches[i]] {
+			box = "[x]"
+		}
+		lines = append(lines, mark+box+" "+p.names[p.matches[i]])
+	}
+	for len(lines) < page+1 {
+		lines = append(lines, "")
+	}
+	lines = append(lines, fmt.Sprintf("%d/%d matching, %d chosen  [tab] choose  [^A] all  [enter] run  [esc] cancel",
+		len(p.matches), len(p.repos), len(p.chosen)))
+
+	var b strings.Builder
+	b.WriteString("\x1b[H")
+	for i, line := range lines {
+		if width > 0 && len(line) > width {
+			line = line[:width]
+		}
+		b.WriteString(line + "\x1b[K")
+		if i < len(lines)-1 {
+			b.WriteString("\r\n")
+		}
+	}
+	// Leave the cursor after the query.
+	fmt.Fprintf(&b, "\x1b[1;%dH", 3+utf8.RuneCountInString(p.query))
+	tty.WriteString(b.String())
+}
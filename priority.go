@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The I/O scheduling classes of ioprio_set(2).
+const (
+	ioRealtime   = 1
+	ioBestEffort = 2
+	ioIdle       = 3
+)
+
+// A priority is what commands are run at, once set.
+type priority struct {
+	nice   *int // The niceness of commands, if set.
+	ionice int  // The I/O class and level of commands, as ioprio_set(2) takes, or 0.
+}
+
+// parseIONice parses an I/O priority given as CLASS[:LEVEL], where CLASS is
+// idle, best-effort, or realtime, and LEVEL, from 0 to 7, is highest at 0.
+func parseIONice(s string) (int, error) {
+	class, level, leveled := strings.Cut(s, ":")
+	classes := map[string]int{"realtime": ioRealtime, "best-effort": ioBestEffort, "idle": ioIdle}
+	c, ok := classes[class]
+	if !ok {
+		return 0, fmt.Errorf("unknown class %q, expected idle, best-effort, or realtime", class)
+	}
+	n := 4
+	if leveled {
+		var err error
+		if n, err = strconv.Atoi(level); err != nil || n < 0 || n > 7 {
+			return 0, fmt.Errorf("bad level %q, expected 0 to 7", level)
+		}
+		if c == ioIdle {
+			return 0, fmt.Errorf("the idle class has no levels")
+		}
+	}
+	if c == ioIdle {
+		n = 0
+	}
+	return c<<13 | n, nil
+}
+
+// Only the first failure to set a command's priority is reported, since if
+// one fails, they all will.
+var priorityFailed sync.Once
+
+// prioritize sets the priority of child, once started. What child runs before
+// then runs at git-walk's priority.
+func (p priority) prioritize(child *exec.Cmd) {
+	var err error
+	pid := child.Process.Pid
+	if p.nice != nil {
+		err = setNice(pid, *p.nice)
+	}
+	if err == nil && p.ionice != 0 {
+		err = setIOPriority(pid, p.ionice)
+	}
+	if err != nil {
+		priorityFailed.Do(func() {
+			output.Lock()
+			defer output.Unlock()
+			clearProgress()
+			fmt.Fprintf(os.Stderr, "setting the priority of %q failed with %v\n", child.Path, err)
+		})
+	}
+}
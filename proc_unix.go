@@ -0,0 +1,50 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// newProcessGroup makes child run in a new process group, so the whole group
+// can be terminated.
+func newProcessGroup(child *exec.Cmd) {
+	if child.SysProcAttr == nil {
+		child.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	child.SysProcAttr.Setpgid = true
+}
+
+// signalProcess asks child, and its process group, if it was run in a new
+// one, to exit, or with kill, kills them.
+func signalProcess(child *exec.Cmd, group, kill bool) {
+	pid := child.Process.Pid
+	if group {
+		pid = -pid
+	}
+	sig := syscall.SIGTERM
+	if kill {
+		sig = syscall.SIGKILL
+	}
+	syscall.Kill(pid, sig)
+}
+
+// setNice sets the niceness of process pid.
+func setNice(pid, nice int) error {
+	return syscall.Setpriority(syscall.PRIO_PROCESS, pid, nice)
+}
+
+// setCommandLine has no need to do anything, since commands are passed their
+// arguments as given.
+func setCommandLine(child *exec.Cmd, cmd []string) {}
+
+// raise signals git-walk with sig.
+func raise(sig os.Signal) {
+	self, _ := os.FindProcess(os.Getpid())
+	self.Signal(sig)
+}
+
+// dumpSignals are the signals asking git-walk how the run is going.
+var dumpSignals = []os.Signal{syscall.SIGUSR1}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+// newProcessGroup makes child run in a new process group, so it can be asked
+// to exit, with a ctrl-break, without git-walk being asked too.
+func newProcessGroup(child *exec.Cmd) {
+	if child.SysProcAttr == nil {
+		child.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	child.SysProcAttr.CreationFlags |= windows.CREATE_NEW_PROCESS_GROUP
+}
+
+// signalProcess asks child to exit, with a ctrl-break, if it was run in a new
+// process group, or with kill, kills it and the processes it started.
+func signalProcess(child *exec.Cmd, group, kill bool) {
+	pid := child.Process.Pid
+	if !kill {
+		if group {
+			windows.GenerateConsoleCtrlEvent(windows.CTRL_BREAK_EVENT, uint32(pid))
+		}
+		return
+	}
+	// Windows doesn't kill the processes a process started along with it.
+	tree := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(pid))
+	if tree.Run() != nil {
+		child.Process.Kill()
+	}
+}
+
+// setNice sets the priority class of process pid to that nearest its
+// niceness.
+func setNice(pid, nice int) error {
+	h, err := windows.OpenProcess(windows.PROCESS_SET_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return err
+	}
+	defer windows.CloseHandle(h)
+	var class uint32
+	switch {
+	case nice >= 15:
+		class = windows.IDLE_PRIORITY_CLASS
+	case nice > 0:
+		class = windows.BELOW_NORMAL_PRIORITY_CLASS
+	case nice == 0:
+		class = windows.NORMAL_PRIORITY_CLASS
+	case nice > -15:
+		class = windows.ABOVE_NORMAL_PRIORITY_CLASS
+	default:
+		class = windows.HIGH_PRIORITY_CLASS
+	}
+	return windows.SetPriorityClass(h, class)
+}
+
+// setCommandLine passes a script run by cmd.exe as written, since cmd.exe
+// doesn't unquote its arguments as other commands do.
+func setCommandLine(child *exec.Cmd, cmd []string) {
+	if len(cmd) != 3 || !strings.EqualFold(cmd[1], "/C") {
+		return
+	}
+	if exe := strings.ToLower(filepath.Base(cmd[0])); exe != "cmd.exe" && exe != "cmd" {
+		return
+	}
+	if child.SysProcAttr == nil {
+		child.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	child.SysProcAttr.CmdLine = syscall.EscapeArg(cmd[0]) + ` /S /C "` + cmd[2] + `"`
+}
+
+// raise does nothing, since processes can't signal themselves on Windows, and
+// git-walk exits as if signaled instead.
+func raise(sig os.Signal) {}
+
+// dumpSignals is empty, since there is no signal to ask git-walk how the run
+// is going on Windows.
+var dumpSignals []os.Signal
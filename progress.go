@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// The progress line, if it is being shown.
+var bar *progress
+
+// clearProgress clears the progress line, if it is shown, so output can be
+// written in its place. It must be called with output locked.
+func clearProgress() {
+	if bar != nil && bar.shown {
+		os.Stderr.WriteString("\r\x1b[K")
+		bar.shown = false
+	}
+}
+
+// A progress line, on the terminal, of how many repos have been found, run
+// in, and are running, and how much longer the rest might take.
+type progress struct {
+	name  func(repo) string
+	start time.Time
+	done  chan struct{}
+	wg    sync.WaitGroup
+
+	lock     sync.Mutex // Guards the following.
+	found    int
+	all      bool // Whether all repos have been found.
+	finished int
+	running  map[int]repo // By seq.
+
+	shown bool // Guarded by output.
+}
+
+// newProgress returns a progress line, if stderr is a terminal, or nil.
+func newProgress(name func(repo) string) *progress {
+	if !term.IsTerminal(int(os.Stderr.Fd())) {
+		return nil
+	}
+	enableEscapes(os.Stderr)
+	return &progress{
+		name:    name,
+		start:   time.Now(),
+		done:    make(chan struct{}),
+		running: map[int]repo{},
+	}
+}
+
+func (p *progress) update(f func()) {
+	if p == nil {
+		return
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	f()
+}
+
+// add records that a repo was found.
+func (p *progress) add() { p.update(func() { p.found++ }) }
+
+// allFound records that all repos were found.
+func (p *progress) allFound() { p.update(func() { p.all = true }) }
+
+// started records that r started being run in.
+func (p *progress) started(r repo) { p.update(func() { p.running[r.seq] = r }) }
+
+// stopped records that r was run in.
+func (p *progress) stopped(r repo) {
+	p.update(func() {
+		delete(p.running, r.seq)
+		p.finished++
+	})
+}
+
+// line describes the progress, in at most width columns.
+func (p *progress) line(width int) string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	total := fmt.Sprintf("%d+", p.found)
+	eta := ""
+	if p.all {
+		total = fmt.Sprint(p.found)
+		if p.finished > 0 && p.finished < p.found {
+			per := time.Since(p.start) / time.Duration(p.finished)
+			left := per * time.Duration(p.found-p.finished)
+			eta = fmt.Sprintf(", about %v left", left.Round(time.Second))
+		}
+	}
+	var runs []repo
+	for _, r := range p.running {
+		runs = append(runs, r)
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].seq < runs[j].seq })
+	var names []string
+	for _, r := range runs {
+		names = append(names, p.name(r))
+	}
+	running := ""
+	if len(names) > 0 {
+		running = fmt.Sprintf(", %d running: %s", len(names), strings.Join(names, " "))
+	}
+	line := fmt.Sprintf("%d/%s done%s%s", p.finished, total, eta, running)
+	if width > 0 && len(line) >= width {
+		line = line[:width-1]
+	}
+	return line
+}
+
+// show keeps the progress line drawn, until stop is called.
+func (p *progress) show() {
+	if p == nil {
+		return
+	}
+	bar = p
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		tick := time.NewTicker(250 * time.Millisecond)
+		defer tick.Stop()
+		for {
+			select {
+			case <-p.done:
+				output.Lock()
+				clearProgress()
+				bar = nil
+				output.Unlock()
+				return
+			case <-tick.C:
+				width, _, _ := term.GetSize(int(os.Stderr.Fd()))
+				line := p.line(width)
+				output.Lock()
+				os.Stderr.WriteString("\r\x1b[K" + line)
+				p.shown = true
+				output.Unlock()
+			}
+		}
+	}()
+}
+
+// stop clears the progress line, and stops drawing it.
+func (p *progress) stop() {
+	if p == nil {
+		return
+	}
+	close(p.done)
+	p.wg.Wait()
+}
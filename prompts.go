@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/term"
+)
+
+// askpassName is what git-walk is run as, when commands run it to ask the
+// user for a password, or the like, as their GIT_ASKPASS or SSH_ASKPASS.
+const askpassName = "git-walk-askpass"
+
+// A promptRelay asks the user the questions of commands run in the
+// background, like for passwords, or passphrases, which would otherwise wait
+// for an answer that never comes. They are asked one at a time, on the user's
+// terminal, while other output waits.
+type promptRelay struct {
+	ctx context.Context
+	dir string // Holds the socket prompts are read from, and askpassName.
+	ln  net.Listener
+
+	asking sync.Mutex // Held while asking.
+
+	lock  sync.Mutex
+	fd    int         // Of the terminal, while reading a password.
+	saved *term.State // How it was, before.
+}
+
+// newPromptRelay returns a relay of the prompts of commands run in
+// git-walk's environment, until ctx is done, or it is closed.
+func newPromptRelay(ctx context.Context) (*promptRelay, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp("", "git-walk-")
+	if err != nil {
+		return nil, err
+	}
+	pr := &promptRelay{ctx: ctx, dir: dir}
+	// Where symlinks can't be made, as on Windows without the privilege, a
+	// hard link will still do.
+	if err := os.Symlink(self, pr.askpass()); err != nil {
+		if err := os.Link(self, pr.askpass()); err != nil {
+			os.RemoveAll(dir)
+			return nil, err
+		}
+	}
+	if pr.ln, err = net.Listen("unix", filepath.Join(dir, "prompts")); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	go pr.serve()
+	return pr, nil
+}
+
+// askpass returns the path git-walk is run as by commands, to ask the user.
+func (pr *promptRelay) askpass() string {
+	self, _ := os.Executable()
+	return filepath.Join(pr.dir, askpassName+filepath.Ext(self))
+}
+
+// setenv sets git-walk's environment, and so that of the commands it runs,
+// so they ask through the relay, unless they already ask in some other way.
+// If parallel, ssh is made to ask through it too, rather than at the terminal,
+// over the others, unless told otherwise.
+func (pr *promptRelay) setenv(parallel bool) {
+	os.Setenv("GIT_WALK_PROMPTS", pr.ln.Addr().String())
+	if os.Getenv("GIT_ASKPASS") == "" {
+		os.Setenv("GIT_ASKPASS", pr.askpass())
+	}
+	if os.Getenv("SSH_ASKPASS") == "" {
+		os.Setenv("SSH_ASKPASS", pr.askpass())
+		if _, ok := os.LookupEnv("SSH_ASKPASS_REQUIRE"); !ok && parallel {
+			os.Setenv("SSH_ASKPASS_REQUIRE", "force")
+		}
+	}
+}
+
+// close stops relaying prompts.
+func (pr *promptRelay) close() {
+	pr.ln.Close()
+	pr.restore()
+	os.RemoveAll(pr.dir)
+}
+
+func (pr *promptRelay) serve() {
+	for {
+		conn, err := pr.ln.Accept()
+		if err != nil {
+			return
+		}
+		go pr.relay(conn)
+	}
+}
+
+// relay reads the name of a repo, and a prompt of a command run in it, from
+// conn, and writes back what the user answers, after a "+", or nothing, if
+// they didn't.
+func (pr *promptRelay) relay(conn net.Conn) {
+	defer conn.Close()
+	req, err := io.ReadAll(conn)
+	if err != nil {
+		return
+	}
+	name, prompt, _ := strings.Cut(string(req), "\x00")
+	if answer, ok := pr.ask(name, prompt); ok {
+		io.WriteString(conn, "+"+answer)
+	}
+}
+
+// ask asks the user prompt, of a command run in the repo name, and returns
+// their answer, and whether they gave one.
+func (pr *promptRelay) ask(name, prompt string) (string, bool) {
+	pr.asking.Lock()
+	defer pr.asking.Unlock()
+	// Keep other output from interrupting the prompt.
+	output.Lock()
+	defer output.Unlock()
+	clearProgress()
+
+	in, err := os.OpenFile(ttyIn, os.O_RDWR, 0)
+	if err != nil {
+		slog.Debug("prompt failed", "repo", name, "err", err)
+		return "", false
+	}
+	defer in.Close()
+	out := in
+	if ttyOut != ttyIn {
+		if out, err = os.OpenFile(ttyOut, os.O_RDWR, 0); err != nil {
+			slog.Debug("prompt failed", "repo", name, "err", err)
+			return "", false
+		}
+		defer out.Close()
+	}
+	slog.Debug("prompt", "repo", name, "prompt", prompt)
+	if name != "" {
+		fmt.Fprintf(out, "%s: ", name)
+	}
+	fmt.Fprint(out, prompt)
+	type reply struct {
+		answer string
+		err    error
+	}
+	got := make(chan reply, 1)
+	go func() {
+		if echoed(prompt) {
+			line, err := bufio.NewReader(in).ReadString('\n')
+			got <- reply{strings.TrimRight(line, "\r\n"), err}
+			return
+		}
+		fd := int(in.Fd())
+		pr.lock.Lock()
+		pr.fd = fd
+		pr.saved, _ = term.GetState(fd)
+		pr.lock.Unlock()
+		password, err := term.ReadPassword(fd)
+		pr.lock.Lock()
+		pr.saved = nil
+		pr.lock.Unlock()
+		fmt.Fprintln(out)
+		got <- reply{string(password), err}
+	}()
+	select {
+	case r := <-got:
+		return r.answer, r.err == nil
+	case <-pr.ctx.Done():
+		// Don't leave the terminal not echoing, or the run waiting.
+		pr.restore()
+		fmt.Fprintln(out)
+		return "", false
+	}
+}
+
+// restore restores the terminal to how it was, if a password is being read.
+func (pr *promptRelay) restore() {
+	pr.lock.Lock()
+	defer pr.lock.Unlock()
+	if pr.saved != nil {
+		term.Restore(pr.fd, pr.saved)
+		pr.saved = nil
+	}
+}
+
+// echoed reports whether the answer to prompt can be shown as it is typed,
+// because it isn't a password or the like.
+func echoed(prompt string) bool {
+	p := strings.ToLower(prompt)
+	return strings.HasPrefix(p, "username") || strings.Contains(p, "(yes/no")
+}
+
+// isAskpass reports whether git-walk was run by a command, to ask the user.
+func isAskpass() bool {
+	base := filepath.Base(os.Args[0])
+	return strings.TrimSuffix(base, filepath.Ext(base)) == askpassName
+}
+
+// askpass asks the user the prompt in args through the relay of the git-walk
+// that ran the command it was run by, and writes their answer to stdout.
+// Returns the exit status: 0 if they answered.
+func askpass(args []string) int {
+	conn, err := net.Dial("unix", os.Getenv("GIT_WALK_PROMPTS"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s failed with %v\n", askpassName, err)
+		return 1
+	}
+	defer conn.Close()
+	io.WriteString(conn, os.Getenv("GIT_WALK_NAME")+"\x00"+strings.Join(args, " "))
+	conn.(*net.UnixConn).CloseWrite()
+	answer, err := io.ReadAll(conn)
+	if err != nil || len(answer) == 0 {
+		return 1
+	}
+	fmt.Println(string(answer[1:]))
+	return 0
+}
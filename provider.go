@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// A hostedRepo is a repo hosted by a service like GitHub.
+type hostedRepo struct {
+	path string // Where to clone it, slash-separated, like org/name.
+	url  string // Where to clone it from.
+}
+
+// A provider is a service hosting repos, like GitHub, that can list them.
+type provider interface {
+	// repos lists the hosted repos, to be cloned over SSH, or HTTPS.
+	repos(ctx context.Context, ssh bool) ([]hostedRepo, error)
+}
+
+// A github provider lists the repos of an organization.
+type github struct {
+	api   string // Like https://api.github.com, or GitHub Enterprise's.
+	org   string
+	token string // If not "", authenticates, so private repos are listed.
+}
+
+func (g github) repos(ctx context.Context, ssh bool) ([]hostedRepo, error) {
+	var repos []hostedRepo
+	next := g.api + "/orgs/" + url.PathEscape(g.org) + "/repos?per_page=100"
+	for next != "" {
+		var page []struct {
+			FullName string `json:"full_name"`
+			CloneURL string `json:"clone_url"`
+			SSHURL   string `json:"ssh_url"`
+		}
+		var err error
+		next, err = getPage(ctx, next, &page, func(req *http.Request) {
+			req.Header.Set("Accept", "application/vnd.github+json")
+			if g.token != "" {
+				req.Header.Set("Authorization", "Bearer "+g.token)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range page {
+			url := r.CloneURL
+			if ssh {
+				url = r.SSHURL
+			}
+			repos = append(repos, hostedRepo{path: r.FullName, url: url})
+		}
+	}
+	return repos, nil
+}
+
+// A gitlab provider lists the projects of a group, and of its subgroups.
+type gitlab struct {
+	api   string // Like https://gitlab.com, or a self-hosted instance's.
+	group string // Like platform/infra.
+	token string
+}
+
+func (g gitlab) repos(ctx context.Context, ssh bool) ([]hostedRepo, error) {
+	var repos []hostedRepo
+	next := g.api + "/api/v4/groups/" + url.PathEscape(g.group) +
+		"/projects?include_subgroups=true&per_page=100"
+	for next != "" {
+		var page []struct {
+			Path    string `json:"path_with_namespace"`
+			HTTPURL string `json:"http_url_to_repo"`
+			SSHURL  string `json:"ssh_url_to_repo"`
+		}
+		var err error
+		next, err = getPage(ctx, next, &page, func(req *http.Request) {
+			if g.token != "" {
+				req.Header.Set("PRIVATE-TOKEN", g.token)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range page {
+			url := r.HTTPURL
+			if ssh {
+				url = r.SSHURL
+			}
+			repos = append(repos, hostedRepo{path: r.Path, url: url})
+		}
+	}
+	return repos, nil
+}
+
+// A bitbucket provider lists the repos of a workspace.
+type bitbucket struct {
+	api       string // Like https://api.bitbucket.org.
+	workspace string
+	token     string
+}
+
+func (b bitbucket) repos(ctx context.Context, ssh bool) ([]hostedRepo, error) {
+	var repos []hostedRepo
+	next := b.api + "/2.0/repositories/" + url.PathEscape(b.workspace) + "?pagelen=100"
+	for next != "" {
+		// Bitbucket links to the next page in the body, not the header.
+		var page struct {
+			Values []struct {
+				FullName string `json:"full_name"`
+				Links    struct {
+					Clone []struct {
+						Name string `json:"name"`
+						Href string `json:"href"`
+					} `json:"clone"`
+				} `json:"links"`
+			} `json:"values"`
+			Next string `json:"next"`
+		}
+		_, err := getPage(ctx, next, &page, func(req *http.Request) {
+			if b.token != "" {
+				req.Header.Set("Authorization", "Bearer "+b.token)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range page.Values {
+			want := "https"
+			if ssh {
+				want = "ssh"
+			}
+			for _, c := range r.Links.Clone {
+				if c.Name == want {
+					repos = append(repos, hostedRepo{path: r.FullName, url: c.Href})
+				}
+			}
+		}
+		next = page.Next
+	}
+	return repos, nil
+}
+
+// A urlList provider lists the repos whose URLs are in a file, one a line.
+type urlList struct {
+	file string
+}
+
+// repos lists the repos of the file, to be cloned from their URLs as they are
+// given, whatever ssh is, into HOST/ORG/NAME. Blank lines, and those starting
+// with #, are ignored, as are URLs to be cloned where an earlier one is, like
+// the same URL over HTTP, or without .git.
+func (l urlList) repos(ctx context.Context, ssh bool) ([]hostedRepo, error) {
+	data, err := os.ReadFile(expandHome(l.file))
+	if err != nil {
+		return nil, err
+	}
+	var repos []hostedRepo
+	listed := map[string]bool{}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if urlHost(line) == "" {
+			return nil, fmt.Errorf("%s:%d: %q is not the URL of a hosted repo", l.file, i+1, line)
+		}
+		path := normalizeRemote(line)
+		for _, part := range strings.Split(path, "/") {
+			if part == "" || part == "." || part == ".." {
+				return nil, fmt.Errorf("%s:%d: %q can't be cloned into %s", l.file, i+1, line, path)
+			}
+		}
+		if listed[path] {
+			slog.Debug("skip", "url", line, "reason", "listed already")
+			continue
+		}
+		listed[path] = true
+		repos = append(repos, hostedRepo{path: path, url: line})
+	}
+	return repos, nil
+}
+
+// newProvider returns the provider named by one of the clone command's
+// options, with its API at api, or if "", at the service's own.
+func newProvider(from, githubOrg, gitlabGroup, workspace, api string, getenv func(string) string) (provider, error) {
+	base := func(def string) string {
+		if api == "" {
+			api = def
+		}
+		return strings.TrimSuffix(api, "/")
+	}
+	var ps []provider
+	if from != "" {
+		ps = append(ps, urlList{from})
+	}
+	if githubOrg != "" {
+		def := getenv("GITHUB_API_URL")
+		if def == "" {
+			def = "https://api.github.com"
+		}
+		ps = append(ps, github{base(def), githubOrg, getenv("GITHUB_TOKEN")})
+	}
+	if gitlabGroup != "" {
+		ps = append(ps, gitlab{base("https://gitlab.com"), gitlabGroup, getenv("GITLAB_TOKEN")})
+	}
+	if workspace != "" {
+		ps = append(ps, bitbucket{base("https://api.bitbucket.org"), workspace, getenv("BITBUCKET_TOKEN")})
+	}
+	if len(ps) != 1 {
+		return nil, fmt.Errorf("clone: one of --from, --github-org, --gitlab-group, or --bitbucket-workspace is needed")
+	}
+	return ps[0], nil
+}
+
+// getPage gets a page of JSON results from url into v, after prepare has
+// prepared the request, and returns the URL of the next page from its Link
+// header, or "" if this was the last page.
+func getPage(ctx context.Context, url string, v interface{}, prepare func(*http.Request)) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return "", err
+	}
+	prepare(req)
+	rsp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: %s", url, rsp.Status)
+	}
+	if err := json.NewDecoder(rsp.Body).Decode(v); err != nil {
+		return "", err
+	}
+	return nextPage(rsp.Header.Get("Link")), nil
+}
+
+var nextLink = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// nextPage returns the URL of the next page of results, from a Link header,
+// or "" if this was the last page.
+func nextPage(link string) string {
+	if m := nextLink.FindStringSubmatch(link); m != nil {
+		return m[1]
+	}
+	return ""
+}
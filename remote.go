@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/pborman/getopt/v2"
+)
+
+// sshOptions are given to ssh, so it fails, rather than asking for passwords
+// no one will type.
+var sshOptions = []string{"-o", "BatchMode=yes"}
+
+// shippedPath is where --ship copies git-walk to on each host, relative to
+// the home directory it is run in.
+const shippedPath = ".cache/git-walk/git-walk"
+
+// walkOptions are the options given to git-walk on other hosts, so it finds,
+// and selects, repos there as it would here.
+var walkOptions = []string{
+	"where", "max-depth", "min-depth", "follow-symlinks", "nested",
+	"default-excludes", "no-default-excludes", "bare", "recurse-submodules",
+	"one-file-system", "skip-fs", "vcs", "worktrees", "walk-errors",
+	"match", "group", "branch", "not-branch", "remote-match", "has-file",
+	"ahead", "behind", "diverged", "dirty", "stashed", "older-than", "newer-than", "if",
+}
+
+// remoteOptions returns the walkOptions seen in set, as they were given,
+// looking in the home directory on other hosts, if not given --where.
+func remoteOptions(set *getopt.Set) []string {
+	var args []string
+	for _, long := range walkOptions {
+		opt := set.Lookup(long)
+		switch {
+		case opt == nil || !opt.Seen():
+			if long == "where" {
+				args = append(args, "--where=.")
+			}
+		case opt.IsFlag():
+			args = append(args, "--"+long)
+		default:
+			if l, ok := opt.Value().(*stringList); ok {
+				for _, v := range *l {
+					args = append(args, "--"+long+"="+v)
+				}
+			} else {
+				args = append(args, "--"+long+"="+opt.String())
+			}
+		}
+	}
+	return args
+}
+
+// sshTo returns the command running the shell script on host.
+func sshTo(ctx context.Context, host, script string) *exec.Cmd {
+	args := append(append([]string(nil), sshOptions...), host, script)
+	return commandContext(ctx, "ssh", args...)
+}
+
+// remoteCommand returns the command running cmd in r, on its host, over ssh,
+// or if here, in the directory ssh starts in.
+func remoteCommand(r repo, cmd []string, here bool) []string {
+	script := quoteWords(cmd)
+	if !here {
+		script = "cd " + quoteWords([]string{r.dir}) + " && " + script
+	}
+	return append(append([]string{"ssh"}, sshOptions...), r.host, script)
+}
+
+// listRemote runs the git-walk at bin on host, listing the repos it finds
+// with args, and calls found with each of them.
+func listRemote(ctx context.Context, host, bin string, args []string, found func(repo)) error {
+	list := quoteWords(append(append([]string{bin}, args...), "list", "-0"))
+	ssh := sshTo(ctx, host, list)
+	var out bytes.Buffer
+	ssh.Stdout, ssh.Stderr = &out, os.Stderr
+	slog.Debug("list remote", "host", host, "command", list)
+	if err := runGroup(ssh); err != nil {
+		return err
+	}
+	for _, dir := range strings.Split(out.String(), "\x00") {
+		if dir = strings.TrimSpace(dir); dir != "" {
+			found(repo{dir: dir, host: host})
+		}
+	}
+	return nil
+}
+
+// ship copies git-walk to host, if it runs the same system, and returns
+// where it was copied to.
+func ship(ctx context.Context, host string) (string, error) {
+	var uname bytes.Buffer
+	probe := sshTo(ctx, host, "uname -sm")
+	probe.Stdout = &uname
+	if err := runGroup(probe); err != nil {
+		return "", fmt.Errorf("uname failed with %v", err)
+	}
+	system := strings.ToLower(strings.Join(strings.Fields(uname.String()), " "))
+	arch := map[string]string{"amd64": "x86_64", "arm64": "aarch64", "386": "i686"}[runtime.GOARCH]
+	if arch == "" {
+		arch = runtime.GOARCH
+	}
+	if want := runtime.GOOS + " " + arch; system != want && system != runtime.GOOS+" "+runtime.GOARCH {
+		return "", fmt.Errorf("%q isn't %q, as git-walk was built for", system, want)
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(self)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	install := fmt.Sprintf("mkdir -p .cache/git-walk && cat > %[1]s.$$ && chmod +x %[1]s.$$ && mv %[1]s.$$ %[1]s", shippedPath)
+	ssh := sshTo(ctx, host, install)
+	ssh.Stdin, ssh.Stderr = f, os.Stderr
+	if err := runGroup(ssh); err != nil {
+		return "", fmt.Errorf("copy failed with %v", err)
+	}
+	return shippedPath, nil
+}
@@ -0,0 +1,497 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// An outcome is what running in a repo did, for reports.
+type outcome struct {
+	code           int // The exit code of the last command run, or -1.
+	took           time.Duration
+	stdout, stderr []byte
+}
+
+// exitCode returns the exit code of a command that returned err, or -1 if it
+// didn't exit.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if eexit, ok := err.(*exec.ExitError); ok {
+		return eexit.ExitCode()
+	}
+	return -1
+}
+
+// outcomes records the outcome of each repo run in.
+type outcomes struct {
+	lock  sync.Mutex
+	byseq map[int]outcome
+}
+
+func newOutcomes() *outcomes {
+	return &outcomes{byseq: map[int]outcome{}}
+}
+
+func (o *outcomes) add(r repo, oc outcome) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.byseq[r.seq] = oc
+}
+
+// A reportRow is a repo, and how running in it went, as reported.
+type reportRow struct {
+	repo   repo
+	name   string
+	noted  string // How the repo is written in notes about running in it.
+	status status
+	outcome
+}
+
+// A report is written, once the run is done, in some format, to a file.
+type report struct {
+	format string
+	path   string // "-" for stdout.
+}
+
+// reportFormats are the formats reports can be written in.
+var reportFormats = map[string]func(w io.Writer, rp *reportData) error{
+	"junit":    writeJUnit,
+	"tap":      writeTAP,
+	"markdown": writeMarkdown,
+	"csv":      writeCSV,
+	"tsv":      writeTSV,
+	"html":     writeHTML,
+}
+
+// parseReport parses a report given as FORMAT=FILE.
+func parseReport(s string) (report, error) {
+	i := strings.Index(s, "=")
+	if i < 0 {
+		return report{}, fmt.Errorf("bad report %q: expected FORMAT=FILE", s)
+	}
+	rp := report{format: s[:i], path: s[i+1:]}
+	if reportFormats[rp.format] == nil {
+		return report{}, fmt.Errorf("bad report %q: unknown format %q", s, rp.format)
+	}
+	return rp, nil
+}
+
+// reportData is what reports are written from.
+type reportData struct {
+	command string
+	start   time.Time
+	took    time.Duration
+	rows    []reportRow // Ordered by name, or as given by --sort.
+	fields  []string    // The columns of CSV and TSV reports.
+}
+
+func newReportData(rn *runner, repos []repo, results *tally, ran *outcomes, start time.Time, fields []string, by string) *reportData {
+	rd := &reportData{command: commandName(rn.cmds), start: start, took: time.Since(start), fields: fields}
+	ran.lock.Lock()
+	defer ran.lock.Unlock()
+	for _, r := range repos {
+		row := reportRow{repo: r, name: rn.display(r), noted: rn.path(r), status: results.statusOf(r)}
+		row.outcome = ran.byseq[r.seq]
+		rd.rows = append(rd.rows, row)
+	}
+	sort.SliceStable(rd.rows, func(i, j int) bool {
+		return rd.rows[i].name < rd.rows[j].name
+	})
+	if by != "path" {
+		less := repoOrder(by, results)
+		sort.SliceStable(rd.rows, func(i, j int) bool {
+			return less(rd.rows[i].repo, rd.rows[j].repo)
+		})
+	}
+	return rd
+}
+
+// count returns how many rows have st.
+func (rd *reportData) count(st status) int {
+	n := 0
+	for _, row := range rd.rows {
+		if row.status == st {
+			n++
+		}
+	}
+	return n
+}
+
+// write writes the report.
+func (rp report) write(rd *reportData) error {
+	if rp.path == "-" {
+		return reportFormats[rp.format](os.Stdout, rd)
+	}
+	f, err := os.Create(rp.path)
+	if err != nil {
+		return err
+	}
+	if err := reportFormats[rp.format](f, rd); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Name     string       `xml:"name,attr"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Skipped  int          `xml:"skipped,attr"`
+	Time     float64      `xml:"time,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name      string      `xml:"name,attr"`
+	Tests     int         `xml:"tests,attr"`
+	Failures  int         `xml:"failures,attr"`
+	Skipped   int         `xml:"skipped,attr"`
+	Time      float64     `xml:"time,attr"`
+	Timestamp string      `xml:"timestamp,attr"`
+	Cases     []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      float64       `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+	Stdout    string        `xml:"system-out,omitempty"`
+	Stderr    string        `xml:"system-err,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr,omitempty"`
+}
+
+// writeJUnit writes a JUnit XML report, with a test case for each repo,
+// failing with the exit code and stderr of the repos that failed.
+func writeJUnit(w io.Writer, rd *reportData) error {
+	suite := junitSuite{
+		Name:      "git-walk: " + rd.command,
+		Tests:     len(rd.rows),
+		Failures:  rd.count(failed),
+		Skipped:   len(rd.rows) - rd.count(failed) - rd.count(succeeded),
+		Time:      rd.took.Seconds(),
+		Timestamp: rd.start.Format("2006-01-02T15:04:05"),
+	}
+	for _, row := range rd.rows {
+		c := junitCase{
+			Name:      row.name,
+			Classname: "git-walk",
+			Time:      row.took.Seconds(),
+			Stdout:    string(row.stdout),
+			Stderr:    string(row.stderr),
+		}
+		switch row.status {
+		case failed:
+			msg := fmt.Sprintf("exit code %d", row.code)
+			if row.code < 0 {
+				msg = "did not exit"
+			}
+			c.Failure = &junitFailure{Message: msg, Type: "failed", Text: string(row.stderr)}
+		case skipped:
+			c.Skipped = &junitSkipped{Message: "skipped"}
+		case pending:
+			c.Skipped = &junitSkipped{Message: "not run"}
+		}
+		suite.Cases = append(suite.Cases, c)
+	}
+	suites := junitSuites{
+		Name:     "git-walk",
+		Tests:    suite.Tests,
+		Failures: suite.Failures,
+		Skipped:  suite.Skipped,
+		Time:     suite.Time,
+		Suites:   []junitSuite{suite},
+	}
+	io.WriteString(w, xml.Header)
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(suites); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// tapDiagnostics are the YAML diagnostics of a TAP test point.
+type tapDiagnostics struct {
+	Repo     string  `yaml:"repo"`
+	Status   string  `yaml:"status"`
+	ExitCode *int    `yaml:"exit_code,omitempty"`
+	Seconds  float64 `yaml:"seconds"`
+	Stdout   string  `yaml:"stdout,omitempty"`
+	Stderr   string  `yaml:"stderr,omitempty"`
+}
+
+// writeTAP writes a TAP version 13 report, with a test point for each repo,
+// and its output as YAML diagnostics.
+func writeTAP(w io.Writer, rd *reportData) error {
+	fmt.Fprintf(w, "TAP version 13\n1..%d\n", len(rd.rows))
+	for i, row := range rd.rows {
+		switch row.status {
+		case succeeded:
+			fmt.Fprintf(w, "ok %d - %s\n", i+1, row.name)
+		case failed:
+			fmt.Fprintf(w, "not ok %d - %s\n", i+1, row.name)
+		default:
+			fmt.Fprintf(w, "ok %d - %s # SKIP %s\n", i+1, row.name, row.status)
+			continue
+		}
+		diag := tapDiagnostics{
+			Repo:    row.repo.dir,
+			Status:  row.status.String(),
+			Seconds: row.took.Seconds(),
+			Stdout:  string(row.stdout),
+			Stderr:  string(row.stderr),
+		}
+		if row.code >= 0 {
+			diag.ExitCode = &row.code
+		}
+		var out strings.Builder
+		enc := yaml.NewEncoder(&out)
+		enc.SetIndent(2)
+		if err := enc.Encode(diag); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "  ---\n")
+		for _, line := range strings.SplitAfter(strings.TrimSuffix(out.String(), "\n"), "\n") {
+			fmt.Fprintf(w, "  %s", line)
+		}
+		if _, err := fmt.Fprintf(w, "\n  ...\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// firstLine returns the first line of output that isn't blank, or a note
+// written by git-walk about running in dir.
+func firstLine(out []byte, dir string) string {
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "cd "+dir+": ") {
+			return line
+		}
+	}
+	return ""
+}
+
+// summary describes, briefly, how running in the row's repo went.
+func (row reportRow) summary() string {
+	switch {
+	case row.status == failed && row.code >= 0:
+		return fmt.Sprintf("failed (exit %d)", row.code)
+	case row.status == failed:
+		return "failed (did not exit)"
+	}
+	return row.status.String()
+}
+
+// result is the first line of the row's output, or if it has none, of its
+// errors.
+func (row reportRow) result() string {
+	if line := firstLine(row.stdout, row.noted); line != "" {
+		return line
+	}
+	return firstLine(row.stderr, row.noted)
+}
+
+// writeMarkdown writes a Markdown table of each repo, its branch, status, and
+// the first line of its output.
+func writeMarkdown(w io.Writer, rd *reportData) error {
+	cell := strings.NewReplacer("|", "\\|", "`", "\\`", "<", "&lt;").Replace
+	fmt.Fprintf(w, "| Repo | Branch | Status | Result |\n")
+	fmt.Fprintf(w, "|------|--------|--------|--------|\n")
+	for _, row := range rd.rows {
+		branch := currentBranch(row.repo.dir)
+		if branch == "" {
+			branch = "(detached)"
+		}
+		fmt.Fprintf(w, "| %s | %s | %s | %s |\n",
+			cell(row.name), cell(branch), row.summary(), cell(row.result()))
+	}
+	_, err := fmt.Fprintf(w, "\n%d repos: %d succeeded, %d failed, %d skipped\n",
+		len(rd.rows), rd.count(succeeded), rd.count(failed), len(rd.rows)-rd.count(succeeded)-rd.count(failed))
+	return err
+}
+
+// reportFields are the columns CSV and TSV reports can have.
+var reportFields = map[string]func(row reportRow) string{
+	"repo":   func(row reportRow) string { return row.name },
+	"path":   func(row reportRow) string { return row.repo.dir },
+	"branch": func(row reportRow) string { return currentBranch(row.repo.dir) },
+	"status": func(row reportRow) string { return row.status.String() },
+	"exit": func(row reportRow) string {
+		if row.status != succeeded && row.status != failed || row.code < 0 {
+			return ""
+		}
+		return fmt.Sprint(row.code)
+	},
+	"duration": func(row reportRow) string {
+		if row.took == 0 {
+			return ""
+		}
+		return fmt.Sprintf("%.3f", row.took.Seconds())
+	},
+	"output": reportRow.result,
+}
+
+// defaultFields are the columns of CSV and TSV reports, by default.
+const defaultFields = "repo,exit,duration,output"
+
+// parseFields parses a comma separated list of report columns.
+func parseFields(s string) ([]string, error) {
+	fields := strings.Split(s, ",")
+	for i, f := range fields {
+		fields[i] = strings.TrimSpace(f)
+		if reportFields[fields[i]] == nil {
+			var known []string
+			for f := range reportFields {
+				known = append(known, f)
+			}
+			sort.Strings(known)
+			return nil, fmt.Errorf("bad field %q: expected one of %s", f, strings.Join(known, ", "))
+		}
+	}
+	return fields, nil
+}
+
+func writeCSV(w io.Writer, rd *reportData) error {
+	return writeDelimited(w, rd, ',')
+}
+
+func writeTSV(w io.Writer, rd *reportData) error {
+	return writeDelimited(w, rd, '\t')
+}
+
+// writeDelimited writes a header of the report's fields, and then a record of
+// them for each repo, separated by comma.
+func writeDelimited(w io.Writer, rd *reportData, comma rune) error {
+	out := csv.NewWriter(w)
+	out.Comma = comma
+	out.Write(rd.fields)
+	for _, row := range rd.rows {
+		var record []string
+		for _, f := range rd.fields {
+			record = append(record, reportFields[f](row))
+		}
+		out.Write(record)
+	}
+	out.Flush()
+	return out.Error()
+}
+
+var htmlReport = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>git-walk: {{.Command}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border-bottom: 1px solid #ddd; padding: 0.3em 0.6em; text-align: left; vertical-align: top; }
+th { cursor: pointer; user-select: none; background: #f4f4f4; }
+th.asc::after { content: " \25b2"; }
+th.desc::after { content: " \25bc"; }
+.failed { color: #b00; }
+.skipped, .pending { color: #888; }
+pre { margin: 0.3em 0; white-space: pre-wrap; background: #f8f8f8; padding: 0.5em; }
+</style>
+</head>
+<body>
+<h1>git-walk: <code>{{.Command}}</code></h1>
+<p>{{.Started}}, took {{.Took}}: {{.Total}} repos, {{.Succeeded}} succeeded, {{.Failed}} failed, {{.Skipped}} skipped.</p>
+<table>
+<thead><tr><th>Repo</th><th>Branch</th><th>Status</th><th>Exit</th><th>Seconds</th><th>Output</th></tr></thead>
+<tbody>
+{{range .Rows}}<tr class="{{.Class}}">
+<td>{{.Name}}</td><td>{{.Branch}}</td><td>{{.Status}}</td><td>{{.Exit}}</td><td>{{.Seconds}}</td>
+<td>{{if .Stdout}}<details><summary>stdout</summary><pre>{{.Stdout}}</pre></details>{{end}}{{if .Stderr}}<details{{if eq .Class "failed"}} open{{end}}><summary>stderr</summary><pre>{{.Stderr}}</pre></details>{{end}}</td>
+</tr>
+{{end}}</tbody>
+</table>
+<script>
+document.querySelectorAll("th").forEach(function (th, col) {
+  th.addEventListener("click", function () {
+    var asc = !th.classList.contains("asc");
+    document.querySelectorAll("th").forEach(function (h) { h.classList.remove("asc", "desc"); });
+    th.classList.add(asc ? "asc" : "desc");
+    var body = document.querySelector("tbody");
+    var rows = Array.from(body.rows);
+    rows.sort(function (a, b) {
+      var x = a.cells[col].textContent, y = b.cells[col].textContent;
+      var n = parseFloat(x) - parseFloat(y);
+      var c = isNaN(n) ? x.localeCompare(y) : n;
+      return asc ? c : -c;
+    });
+    rows.forEach(function (row) { body.appendChild(row); });
+  });
+});
+</script>
+</body>
+</html>
+`))
+
+type htmlRow struct {
+	Name, Branch, Status, Class, Exit, Seconds string
+	Stdout, Stderr                             string
+}
+
+// writeHTML writes a single HTML page, with a table of the repos that can be
+// sorted by any column, and the output of each, which can be expanded.
+func writeHTML(w io.Writer, rd *reportData) error {
+	page := struct {
+		Command, Started, Took            string
+		Total, Succeeded, Failed, Skipped int
+		Rows                              []htmlRow
+	}{
+		Command:   rd.command,
+		Started:   rd.start.Format(time.RFC1123),
+		Took:      rd.took.Round(time.Millisecond).String(),
+		Total:     len(rd.rows),
+		Succeeded: rd.count(succeeded),
+		Failed:    rd.count(failed),
+	}
+	page.Skipped = page.Total - page.Succeeded - page.Failed
+	for _, row := range rd.rows {
+		class := row.status.String()
+		if row.status == pending {
+			class = "pending"
+		}
+		page.Rows = append(page.Rows, htmlRow{
+			Name:    row.name,
+			Branch:  currentBranch(row.repo.dir),
+			Status:  row.summary(),
+			Class:   class,
+			Exit:    reportFields["exit"](row),
+			Seconds: reportFields["duration"](row),
+			Stdout:  string(row.stdout),
+			Stderr:  string(row.stderr),
+		})
+	}
+	return htmlReport.Execute(w, page)
+}
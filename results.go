@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// A repoResult is how running in a repo went, written by --results-dir as
+// NAME.json, next to its output, NAME.stdout and NAME.stderr.
+type repoResult struct {
+	Repo     string    `json:"repo"` // The repo's absolute path, or on another host, its path there.
+	Name     string    `json:"name"`
+	Host     string    `json:"host,omitempty"`
+	Branch   string    `json:"branch,omitempty"`
+	Command  string    `json:"command"` // The commands run, as a line of shell.
+	Status   string    `json:"status"`
+	Exit     *int      `json:"exit"` // Or null, if the command didn't exit, as when killed.
+	Started  time.Time `json:"started"`
+	Duration float64   `json:"duration_seconds"`
+	Stdout   string    `json:"stdout"` // Paths relative to the results dir.
+	Stderr   string    `json:"stderr"`
+}
+
+// An indexEntry is a repo found, in the index of the results dir.
+type indexEntry struct {
+	Repo   string `json:"repo"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Result string `json:"result,omitempty"` // The path of its result, if it was run in.
+}
+
+// A resultsIndex is index.json, of the results dir: the stats of the run, as
+// --stats-json writes, and the repos found.
+type resultsIndex struct {
+	*runStats
+	Repos []indexEntry `json:"repos"`
+}
+
+// A resultsDir is where the result of running in each repo is written, and
+// once done, the index of them all.
+type resultsDir struct {
+	dir string
+
+	lock    sync.Mutex
+	results map[int]string // The path of each repo's result, relative to dir, by seq.
+}
+
+func newResultsDir(dir string) *resultsDir {
+	return &resultsDir{dir: dir, results: map[int]string{}}
+}
+
+// write writes how running in r went, and its output.
+func (rd *resultsDir) write(rn *runner, r repo, st status, start time.Time, code int, took time.Duration, stdout, stderr *spool) error {
+	base := rn.fileOf("", r, "")
+	path := filepath.Join(rd.dir, base)
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+	if err := writeSpool(path+".stdout", stdout); err != nil {
+		return err
+	}
+	if err := writeSpool(path+".stderr", stderr); err != nil {
+		return err
+	}
+	res := repoResult{
+		Repo:     absDir(r.dir),
+		Name:     rn.name(r),
+		Host:     r.host,
+		Command:  rn.commandLine(r),
+		Status:   st.String(),
+		Started:  start,
+		Duration: took.Seconds(),
+		Stdout:   filepath.ToSlash(base + ".stdout"),
+		Stderr:   filepath.ToSlash(base + ".stderr"),
+	}
+	if r.host != "" {
+		res.Repo = r.dir
+	} else if r.vcs == "" {
+		res.Branch = currentBranch(r.dir)
+	}
+	if code >= 0 {
+		res.Exit = &code
+	}
+	if err := writeJSONFile(path+".json", res); err != nil {
+		return err
+	}
+	rd.lock.Lock()
+	defer rd.lock.Unlock()
+	rd.results[r.seq] = filepath.ToSlash(base + ".json")
+	return nil
+}
+
+// writeIndex writes index.json, of the run, and each of repos, as results
+// went.
+func (rd *resultsDir) writeIndex(rn *runner, stats *runStats, repos []repo, results *tally) error {
+	if err := os.MkdirAll(rd.dir, 0777); err != nil {
+		return err
+	}
+	rd.lock.Lock()
+	defer rd.lock.Unlock()
+	index := resultsIndex{runStats: stats, Repos: []indexEntry{}}
+	for _, r := range repos {
+		index.Repos = append(index.Repos, indexEntry{
+			Repo:   absDir(r.dir),
+			Name:   rn.name(r),
+			Status: results.statusOf(r).String(),
+			Result: rd.results[r.seq],
+		})
+	}
+	return writeJSONFile(rd.indexPath(), index)
+}
+
+// indexPath returns the path of the index.
+func (rd *resultsDir) indexPath() string {
+	return filepath.Join(rd.dir, "index.json")
+}
+
+// writeSpool writes what s holds to the file at path.
+func writeSpool(path string, s *spool) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := s.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeJSONFile writes v, as indented JSON, to the file at path.
+func writeJSONFile(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
@@ -0,0 +1,646 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"text/template"
+	"time"
+)
+
+// Serialize writing of multi-line output so it is not interleaved.
+var output sync.Mutex
+
+// A runner runs a command in repos.
+type runner struct {
+	cmds   [][]string // Commands to run, in order.
+	roots  []string   // Where repos were looked for, to name them by.
+	quiet  bool       // Don't print the commands being run.
+	direct bool       // Output directly to the console, instead of buffering.
+	stream bool       // Output each line as it is written, prefixed by the repo.
+	order  *order     // If not nil, output in the order of repos' paths.
+	here   bool       // Run commands where git-walk was run, rather than in repos.
+
+	// How repos are referred to in output: "rel", "abs", or "name", or if "",
+	// by their path in banners, and relative to their root elsewhere.
+	pathFormat string
+
+	header *template.Template // If not nil, written before each repo's output, in place of banners.
+	footer *template.Template // If not nil, written after each repo's output.
+
+	input   []byte // If not nil, given to each command as its stdin.
+	inherit bool   // Give commands git-walk's stdin, as they run one at a time.
+
+	beforeEach string // If not "", a shell script run in each repo first, that must succeed.
+	afterEach  string // If not "", a shell script run in each repo after, told how it went.
+
+	config    *config // If not nil, the settings of particular repos.
+	defaulted bool    // The commands are the default, which repos' settings may replace.
+
+	skipEmpty  bool // Print nothing for commands that succeed without output.
+	colorOut   bool // Color the lines written about commands to stdout.
+	colorErr   bool // Color the lines written about commands to stderr.
+	forceColor bool // Make commands color their output, though it isn't to a terminal.
+	stripColor bool // Strip colors from the output of commands.
+
+	// If not nil, called to run each command in-process, instead of it.
+	call func(ctx context.Context, r repo, stdout, stderr io.Writer) error
+	// If not nil, called with the output of each repo, instead of writing it.
+	sink func(r repo, stdout, stderr []byte)
+	// If not nil, called when a command in r fails, seemingly rate limited.
+	throttled func(r repo)
+
+	timeout  time.Duration   // Kill commands that run longer, if positive.
+	priority priority        // What commands are run at.
+	ctx      context.Context // Kill commands when done.
+
+	interrupt func(os.Signal) // Called when a command is killed by a signal.
+
+	retries    int           // Retry failed commands this many times.
+	retryDelay time.Duration // Delay before the first retry, doubling after.
+	results    *tally        // Where retries are recorded.
+	ran        *outcomes     // If not nil, where the outcome of each repo is recorded.
+	logDir     string        // If not "", where the output of each repo is written.
+	resultsTo  *resultsDir   // If not nil, where how running in each repo went is written.
+
+	total int32 // Count of repos found, once known, accessed atomically.
+}
+
+// rootOf returns the root r was found in.
+func (rn *runner) rootOf(r repo) string {
+	return rootOf(rn.roots, r.dir)
+}
+
+// name returns a short name for r, its path relative to its root, or for
+// repos on other hosts, the host and its path there.
+func (rn *runner) name(r repo) string {
+	if r.host != "" {
+		return r.host + ":" + r.dir
+	}
+	rel, err := filepath.Rel(rn.rootOf(r), r.dir)
+	switch {
+	case err != nil || strings.HasPrefix(rel, ".."):
+		return r.dir
+	case rel == ".":
+		return filepath.Base(r.dir)
+	}
+	return rel
+}
+
+// display returns how r is referred to in output, other than banners.
+func (rn *runner) display(r repo) string {
+	if r.host != "" {
+		return rn.name(r)
+	}
+	switch rn.pathFormat {
+	case "abs":
+		if abs, err := filepath.Abs(r.dir); err == nil {
+			return abs
+		}
+		return r.dir
+	case "name":
+		return filepath.Base(r.dir)
+	}
+	return rn.name(r)
+}
+
+// path returns how r is referred to in banners, and notes about running in it.
+func (rn *runner) path(r repo) string {
+	if rn.pathFormat == "" && r.host == "" {
+		return r.dir
+	}
+	return rn.display(r)
+}
+
+// env returns the GIT_WALK_* environment variables describing r.
+func (rn *runner) env(r repo) []string {
+	abs := func(path string) string {
+		if abs, err := filepath.Abs(path); err == nil {
+			return abs
+		}
+		return path
+	}
+	env := []string{
+		"GIT_WALK_DIR=" + abs(r.dir),
+		"GIT_WALK_NAME=" + rn.name(r),
+		"GIT_WALK_ROOT=" + abs(rn.rootOf(r)),
+		fmt.Sprintf("GIT_WALK_INDEX=%d", r.seq+1),
+		"GIT_WALK_VCS=" + vcsOf(r).name(),
+	}
+	// The total is only known once all the repos have been found.
+	if total := atomic.LoadInt32(&rn.total); total > 0 {
+		env = append(env, fmt.Sprintf("GIT_WALK_TOTAL=%d", total))
+	}
+	for _, o := range rn.overrides(r) {
+		var names []string
+		for name := range o.Env {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			env = append(env, name+"="+o.Env[name])
+		}
+	}
+	return env
+}
+
+// found records that total repos were found, once they all have been.
+func (rn *runner) found(total int) {
+	atomic.StoreInt32(&rn.total, int32(total))
+}
+
+// shellCommand returns the command to run script with the user's shell.
+func shellCommand(script string) []string {
+	if runtime.GOOS == "windows" {
+		comspec := os.Getenv("COMSPEC")
+		if comspec == "" {
+			comspec = "cmd.exe"
+		}
+		return []string{comspec, "/C", script}
+	}
+	shell := os.Getenv("SHELL")
+	if shell == "" {
+		shell = "/bin/sh"
+	}
+	return []string{shell, "-c", script}
+}
+
+// overrides returns the settings of r in the config, in order.
+func (rn *runner) overrides(r repo) []*repoOverride {
+	if rn.config == nil {
+		return nil
+	}
+	return rn.config.overridesOf(rn.rootOf(r), r.dir)
+}
+
+// commandsOf returns the commands run in r, which are those of its settings,
+// if it has any, or of its VCS, in place of the default.
+func (rn *runner) commandsOf(r repo) [][]string {
+	cmds := rn.cmds
+	if rn.defaulted {
+		if r.vcs != "" {
+			cmds = [][]string{vcsOf(r).status()}
+		}
+		for _, o := range rn.overrides(r) {
+			if o.command != nil {
+				cmds = [][]string{o.command}
+			}
+		}
+	}
+	return cmds
+}
+
+// banner describes the command run in r, as a line of shell.
+func (rn *runner) banner(r repo) string {
+	var b strings.Builder
+	for _, cmd := range rn.commandsOf(r) {
+		b.WriteString(rn.bannerOf(r, expand(cmd, r, rn.here), rn.here))
+	}
+	return b.String()
+}
+
+// withoutBanners returns a copy of out, the output of r, without the banners
+// written into it before the output of each command.
+func (rn *runner) withoutBanners(r repo, out []byte) []byte {
+	out = append([]byte(nil), out...)
+	at := 0
+	for _, cmd := range rn.commandsOf(r) {
+		banner := paint(rn.colorOut, green, rn.bannerOf(r, expand(cmd, r, rn.here), rn.here))
+		for i := at; i < len(out); {
+			j := bytes.Index(out[i:], []byte(banner))
+			if j < 0 {
+				break
+			}
+			if i+j == 0 || out[i+j-1] == '\n' {
+				out = append(out[:i+j], out[i+j+len(banner):]...)
+				at = i + j
+				break
+			}
+			i += j + 1
+		}
+	}
+	return out
+}
+
+// bannerOf describes cmd, as run in r, or if here, where git-walk was run.
+func (rn *runner) bannerOf(r repo, cmd []string, here bool) string {
+	if here {
+		return fmt.Sprintf("%s%s\n", strings.Join(cmd, " "), label(r))
+	}
+	return fmt.Sprintf("cd %s; %s%s\n", rn.path(r), strings.Join(cmd, " "), label(r))
+}
+
+// execute runs the commands in r, in order, and returns its status. Commands
+// that fail are retried, and if they still fail, the rest are not run.
+func (rn *runner) execute(r repo) status {
+	slog.Debug("execute", "repo", rn.path(r))
+
+	// Repos whose settings limit how many of them are run in at once wait
+	// for a slot, taken in the order of the settings, so none wait on
+	// another holding a slot they wait for.
+	for _, o := range rn.overrides(r) {
+		if o.slots == nil {
+			continue
+		}
+		select {
+		case o.slots <- struct{}{}:
+		default:
+			slog.Debug("wait for a slot", "repo", rn.path(r), "jobs", o.Jobs)
+			select {
+			case o.slots <- struct{}{}:
+			case <-rn.ctx.Done():
+				return pending
+			}
+		}
+		defer func(o *repoOverride) { <-o.slots }(o)
+	}
+
+	start := time.Now()
+	stdout, stderr := new(spool), new(spool)
+	headed := rn.writeHeader(r, stderr)
+	var err error
+	if rn.beforeEach != "" {
+		err = rn.runHook(r, "before-each", rn.beforeEach, nil, stdout, stderr)
+	}
+	for _, cmd := range rn.commandsOf(r) {
+		if err != nil {
+			break
+		}
+		err = rn.retry(r, cmd, stdout, stderr)
+	}
+	code, took := exitCode(err), time.Since(start)
+	st := failed
+	switch err {
+	case nil:
+		st = succeeded
+	case errCanceled:
+		st = pending
+	}
+	if rn.afterEach != "" && st != pending {
+		rn.runHook(r, "after-each", rn.afterEach, []string{
+			"GIT_WALK_STATUS=" + st.String(),
+			fmt.Sprintf("GIT_WALK_EXIT=%d", code),
+		}, stdout, stderr)
+	}
+	if rn.results != nil {
+		rn.results.wrote(stdout.Len() + stderr.Len())
+	}
+	if err != nil && err != errCanceled && rn.throttled != nil && rateLimited.Match(stderr.Bytes()) {
+		rn.throttled(r)
+	}
+	if rn.ran != nil {
+		rn.ran.add(r, outcome{
+			code:   code,
+			took:   took,
+			stdout: rn.withoutBanners(r, stdout.Bytes()),
+			stderr: append([]byte(nil), stderr.Bytes()...),
+		})
+	}
+	if rn.logDir != "" {
+		if err := rn.writeLog(r, start, code, took, stdout, stderr); err != nil {
+			output.Lock()
+			fmt.Fprintf(os.Stderr, "log %q failed with %v\n", r.dir, err)
+			output.Unlock()
+		}
+	}
+	if rn.resultsTo != nil {
+		if err := rn.resultsTo.write(rn, r, st, start, code, took, stdout, stderr); err != nil {
+			output.Lock()
+			fmt.Fprintf(os.Stderr, "write the result of %q failed with %v\n", r.dir, err)
+			output.Unlock()
+		}
+	}
+	rn.emitSpools(r, rn.frame(r, headed, st, code, took, stdout, stderr), stderr)
+	return st
+}
+
+// retry runs cmd in r, until it succeeds or has been retried too many times.
+// Its placeholders are replaced with their values for r.
+func (rn *runner) retry(r repo, cmd []string, stdout, stderr *spool) error {
+	delay := rn.retryDelay
+	for try := 0; ; try++ {
+		err := rn.attempt(r, cmd, stdout, stderr)
+		if err == nil || err == errCanceled || try >= rn.retries {
+			return err
+		}
+		fmt.Fprintf(stderr, "cd %s: retrying in %v (%d of %d)\n",
+			rn.path(r), delay, try+1, rn.retries)
+		if rn.results != nil {
+			rn.results.retried(r)
+		}
+		select {
+		case <-time.After(delay):
+		case <-rn.ctx.Done():
+		}
+		delay *= 2
+	}
+}
+
+// attempt runs cmd in r once, writing its output, and whether it succeeded,
+// to stdout and stderr.
+func (rn *runner) attempt(r repo, cmd []string, stdout, stderr *spool) error {
+	here := rn.here
+	cmd = expand(cmd, r, here)
+	var out, errOut io.Writer
+
+	switch {
+	case rn.direct:
+		out, errOut = os.Stdout, os.Stderr
+	case rn.stream:
+		prefix := rn.display(r) + " | "
+		stdout := &prefixWriter{w: os.Stdout, prefix: prefix}
+		stderr := &prefixWriter{w: os.Stderr, prefix: prefix}
+		defer stdout.Flush()
+		defer stderr.Flush()
+		out, errOut = stdout, stderr
+		if !rn.quiet && rn.header == nil {
+			output.Lock()
+			fmt.Print(prefix + rn.bannerOf(r, cmd, here))
+			output.Unlock()
+		}
+	default:
+		out, errOut = new(spool), new(spool)
+	}
+
+	var err error
+	if rn.call != nil {
+		err = rn.callIn(r, rn.strip(out), rn.strip(errOut))
+	} else {
+		run, env := cmd, append(os.Environ(), rn.env(r)...)
+		if rn.forceColor {
+			run, env = forceColor(run, env)
+		}
+		if r.host != "" {
+			run = remoteCommand(r, run, here)
+		}
+		child := exec.Command(run[0], run[1:]...)
+		setCommandLine(child, run)
+		if r.host == "" && !here {
+			child.Dir = r.dir
+		}
+		child.Env = env
+		switch {
+		case rn.input != nil:
+			child.Stdin = bytes.NewReader(rn.input)
+		case rn.inherit:
+			child.Stdin = os.Stdin
+		}
+		child.Stdout, child.Stderr = rn.strip(out), rn.strip(errOut)
+		err = rn.run(child)
+	}
+
+	if err == errCanceled {
+		fmt.Fprint(stderr, paint(rn.colorErr, yellow, fmt.Sprintf("cd %s: `%s` canceled%s\n",
+			rn.path(r), strings.Join(cmd, " "), label(r))))
+	} else if err == errTimedOut {
+		fmt.Fprint(stderr, paint(rn.colorErr, yellow, fmt.Sprintf("cd %s: `%s` timed out after %v%s\n",
+			rn.path(r), strings.Join(cmd, " "), rn.timeout, label(r))))
+	} else if err == nil {
+		if !rn.quiet && !rn.stream && rn.header == nil && !rn.silent(out, errOut) {
+			stdout.WriteString(paint(rn.colorOut, green, rn.bannerOf(r, cmd, here)))
+		}
+
+	} else if eexit, ok := err.(*exec.ExitError); ok {
+		fmt.Fprint(stderr, paint(rn.colorErr, red, fmt.Sprintf("cd %s: `%s` failed on %v%s\n",
+			rn.path(r), strings.Join(cmd, " "), eexit, label(r))))
+
+		// If child was signaled, stop the run as if we were signaled.
+		ws, ok := eexit.Sys().(syscall.WaitStatus)
+		if ok && ws.Signaled() && rn.interrupt != nil {
+			rn.interrupt(ws.Signal())
+		}
+	} else {
+		fmt.Fprint(stderr, paint(rn.colorErr, red, fmt.Sprintf("cd %s: `%s` failed on %v%s\n",
+			rn.path(r), strings.Join(cmd, " "), err, label(r))))
+	}
+	if out, ok := out.(*spool); ok {
+		errOut := errOut.(*spool)
+		out.WriteTo(stdout)
+		errOut.WriteTo(stderr)
+		out.Close()
+		errOut.Close()
+	}
+	return err
+}
+
+// strip returns w, stripping the colors written to it, if they should be.
+func (rn *runner) strip(w io.Writer) io.Writer {
+	if rn.stripColor {
+		return &stripWriter{w: w}
+	}
+	return w
+}
+
+// silent reports whether a command that succeeded, writing out and errOut,
+// should go unmentioned, because it wrote nothing.
+func (rn *runner) silent(out, errOut io.Writer) bool {
+	stdout, ok := out.(*spool)
+	stderr, ok2 := errOut.(*spool)
+	return rn.skipEmpty && ok && ok2 && stdout.Len() == 0 && stderr.Len() == 0
+}
+
+// callIn calls the in-process command for r, canceling it if it runs for
+// longer than the timeout, or if the run is canceled.
+func (rn *runner) callIn(r repo, stdout, stderr io.Writer) error {
+	if rn.ctx.Err() != nil {
+		return errCanceled
+	}
+	ctx := rn.ctx
+	if rn.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, rn.timeout)
+		defer cancel()
+	}
+	err := rn.call(ctx, r, stdout, stderr)
+	switch {
+	case rn.ctx.Err() != nil:
+		return errCanceled
+	case ctx.Err() != nil:
+		return errTimedOut
+	}
+	return err
+}
+
+var (
+	errTimedOut = errors.New("timed out")
+	errCanceled = errors.New("canceled")
+)
+
+// run runs child, killing it and any processes it started if it runs for
+// longer than the timeout, or if the run is canceled.
+func (rn *runner) run(child *exec.Cmd) error {
+	if rn.ctx.Err() != nil {
+		return errCanceled
+	}
+	if rn.timeout <= 0 && rn.ctx.Done() == nil {
+		if err := child.Start(); err != nil {
+			return err
+		}
+		exited := logStarted(child)
+		rn.priority.prioritize(child)
+		return exited(child.Wait())
+	}
+	// Run in a new process group, so the whole group can be killed. Direct
+	// output is to the console, where the command stays in the foreground
+	// process group so pagers and terminal signals reach it.
+	if !rn.direct {
+		newProcessGroup(child)
+	}
+	if err := child.Start(); err != nil {
+		return err
+	}
+	if !rn.direct {
+		groups.started(child)
+		defer groups.exited(child)
+	}
+	exited := logStarted(child)
+	rn.priority.prioritize(child)
+	var expired <-chan time.Time
+	if rn.timeout > 0 {
+		timer := time.NewTimer(rn.timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	waited := make(chan error, 1)
+	go func() { waited <- child.Wait() }()
+
+	select {
+	case err := <-waited:
+		return exited(err)
+	case <-expired:
+		rn.terminate(child, waited)
+		return exited(errTimedOut)
+	case <-rn.ctx.Done():
+		rn.terminate(child, waited)
+		return exited(errCanceled)
+	}
+}
+
+// killGrace is how long a terminated command has to exit before it is killed.
+const killGrace = 3 * time.Second
+
+// terminate asks child to exit, and kills it if it hasn't exited within the
+// killGrace period. Returns once child has been waited for.
+func (rn *runner) terminate(child *exec.Cmd, waited <-chan error) {
+	slog.Debug("terminating", "pid", child.Process.Pid)
+	signalProcess(child, !rn.direct, false)
+	select {
+	case <-waited:
+	case <-time.After(killGrace):
+		slog.Debug("killing", "pid", child.Process.Pid, "grace", killGrace)
+		signalProcess(child, !rn.direct, true)
+		<-waited
+	}
+}
+
+// commandContext returns the command running name with args, as
+// exec.CommandContext does, but in a new process group, all of which is asked
+// to exit once ctx is done, so none of the processes it started, like ssh or
+// credential helpers, are left running. The command is killed if it hasn't
+// exited within the killGrace period.
+func commandContext(ctx context.Context, name string, args ...string) *exec.Cmd {
+	child := exec.CommandContext(ctx, name, args...)
+	newProcessGroup(child)
+	child.Cancel = func() error {
+		slog.Debug("terminating", "pid", child.Process.Pid)
+		signalProcess(child, true, false)
+		return nil
+	}
+	child.WaitDelay = killGrace
+	return child
+}
+
+// runGroup runs child, returned by commandContext, as its Run does, noting its
+// process group while it runs, to be killed if git-walk exits first.
+func runGroup(child *exec.Cmd) error {
+	if err := child.Start(); err != nil {
+		return err
+	}
+	groups.started(child)
+	defer groups.exited(child)
+	return child.Wait()
+}
+
+// emit writes the output of running in r, now, or if ordered, once all the
+// repos before r have been written.
+func (rn *runner) emit(r repo, stdout, stderr []byte) {
+	rn.emitSpools(r, spoolOf(stdout), spoolOf(stderr))
+}
+
+// emitSpools emits output that was spooled, closing the spools once written.
+func (rn *runner) emitSpools(r repo, stdout, stderr *spool) {
+	if rn.sink != nil {
+		rn.sink(r, stdout.Bytes(), stderr.Bytes())
+		stdout.Close()
+		stderr.Close()
+		return
+	}
+	if rn.order != nil {
+		rn.order.done(r.seq, stdout, stderr)
+		return
+	}
+	output.Lock()
+	defer output.Unlock()
+	writeSpools(stdout, stderr)
+}
+
+// writeSpools writes stdout and stderr to the console, and closes them. It
+// must be called with output locked.
+func writeSpools(stdout, stderr *spool) {
+	clearProgress()
+	stdout.WriteTo(os.Stdout)
+	stderr.WriteTo(os.Stderr)
+	stdout.Close()
+	stderr.Close()
+}
+
+// A prefixWriter writes each complete line written to it to w, prefixed, and
+// without interleaving with other output.
+type prefixWriter struct {
+	w      io.Writer
+	prefix string
+	buf    []byte // A partial line.
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.buf = append(p.buf, b...)
+	i := bytes.LastIndexByte(p.buf, '\n')
+	if i < 0 {
+		return len(b), nil
+	}
+	lines := p.buf[:i+1]
+	var out bytes.Buffer
+	for len(lines) > 0 {
+		j := bytes.IndexByte(lines, '\n')
+		out.WriteString(p.prefix)
+		out.Write(lines[:j+1])
+		lines = lines[j+1:]
+	}
+	p.buf = append(p.buf[:0], p.buf[i+1:]...)
+	output.Lock()
+	defer output.Unlock()
+	clearProgress()
+	if _, err := p.w.Write(out.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Flush writes any partial last line.
+func (p *prefixWriter) Flush() {
+	if len(p.buf) > 0 {
+		p.Write([]byte("\n"))
+	}
+}
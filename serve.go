@@ -0,0 +1,400 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A server keeps an index of the repos in a tree, looking for them again now
+// and then, and serves it over HTTP, along with the repos' status, and runs
+// commands in them.
+type server struct {
+	rn      runner   // Runs commands in repos, once given them.
+	filters []filter // Select the repos to read and run in.
+	jobs    int      // How many repos to read or run in at once.
+	metrics *metrics
+
+	allowRun bool     // Whether POST /run runs commands.
+	token    string   // If not "", what requests must be authorized by, as a bearer token.
+	hosts    []string // What requests may be addressed to, other than localhost.
+
+	// Looks for the repos, returning them, and the number of errors.
+	walk func() ([]repo, int)
+
+	lock   sync.Mutex // Guards the following.
+	repos  []repo
+	walked time.Time
+	errors int
+}
+
+// rescan looks for the repos again, and reads their status, for the metrics.
+func (s *server) rescan() {
+	repos, errors := s.walk()
+	s.lock.Lock()
+	s.repos, s.walked, s.errors = repos, time.Now(), errors
+	s.lock.Unlock()
+	s.metrics.found(repos)
+	s.each(context.Background(), s.selected(), func(r repo) {
+		s.metrics.read(readStatus(r))
+	})
+}
+
+// serve serves on addr until ctx is done, looking for repos every rescan.
+func (s *server) serve(ctx context.Context, addr string, rescan time.Duration) error {
+	s.rescan()
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "serving %d repos on http://%s\n", len(s.repos), l.Addr())
+	s.hosts = []string{addr, l.Addr().String()}
+
+	srv := &http.Server{
+		Handler:     s.handler(),
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	go func() {
+		tick := time.NewTicker(rescan)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				s.rescan()
+			case <-ctx.Done():
+				done, cancel := context.WithTimeout(context.Background(), killGrace)
+				defer cancel()
+				srv.Shutdown(done)
+				return
+			}
+		}
+	}()
+	if err := srv.Serve(l); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /repos", s.listRepos)
+	mux.HandleFunc("POST /rescan", s.rescanRepos)
+	mux.HandleFunc("GET /status", s.readRepos)
+	if s.allowRun {
+		mux.HandleFunc("POST /run", s.runRepos)
+	}
+	mux.Handle("GET /metrics", s.metrics)
+	return s.guard(mux)
+}
+
+// guard serves requests with h that carry the token, if there is one, and
+// aren't sent by the pages of other sites, as browsers let any page do, even
+// those of sites whose names they were led to resolve to this host.
+func (s *server) guard(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !s.addressed(req.Host) {
+			http.Error(w, "forbidden host", http.StatusForbidden)
+			return
+		}
+		if s.token != "" {
+			auth := req.Header.Get("Authorization")
+			if subtle.ConstantTimeCompare([]byte(auth), []byte("Bearer "+s.token)) != 1 {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		if origin := req.Header.Get("Origin"); origin != "" {
+			if u, err := url.Parse(origin); err != nil || u.Host != req.Host {
+				http.Error(w, "forbidden origin", http.StatusForbidden)
+				return
+			}
+		}
+		h.ServeHTTP(w, req)
+	})
+}
+
+// addressed reports whether host, of a request, is this server: localhost, a
+// loopback address, or the address it listens at.
+func (s *server) addressed(host string) bool {
+	for _, h := range s.hosts {
+		if host == h {
+			return true
+		}
+	}
+	name := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		name = h
+	}
+	if name == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(strings.Trim(name, "[]"))
+	return ip != nil && ip.IsLoopback()
+}
+
+// readToken returns the token in the file at path, or "" if path is "".
+func readToken(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+	data, err := os.ReadFile(expandHome(path))
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("%s is empty", path)
+	}
+	return token, nil
+}
+
+type repoJSON struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+type indexJSON struct {
+	Root   string     `json:"root"`
+	Roots  []string   `json:"roots,omitempty"` // All of them, if more than one.
+	Walked time.Time  `json:"walked"`
+	Errors int        `json:"errors"`
+	Repos  []repoJSON `json:"repos"`
+}
+
+func (s *server) index() indexJSON {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	ix := indexJSON{Root: s.rn.roots[0], Walked: s.walked, Errors: s.errors, Repos: []repoJSON{}}
+	if len(s.rn.roots) > 1 {
+		ix.Roots = s.rn.roots
+	}
+	for _, r := range s.repos {
+		ix.Repos = append(ix.Repos, repoJSON{s.rn.name(r), r.dir})
+	}
+	return ix
+}
+
+// selected returns the indexed repos that pass the server's filters, and any
+// others.
+func (s *server) selected(others ...filter) []repo {
+	s.lock.Lock()
+	repos := s.repos
+	s.lock.Unlock()
+	filters := append(append([]filter{}, s.filters...), others...)
+	var found []repo
+	for _, r := range repos {
+		if selected(filters, r.dir) {
+			found = append(found, r)
+		}
+	}
+	return found
+}
+
+// each calls f with each of repos, running up to s.jobs at once.
+func (s *server) each(ctx context.Context, repos []repo, f func(repo)) {
+	var wg sync.WaitGroup
+	jobs := make(chan struct{}, s.jobs)
+	for _, r := range repos {
+		select {
+		case jobs <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(r repo) {
+			defer wg.Done()
+			defer func() { <-jobs }()
+			f(r)
+		}(r)
+	}
+	wg.Wait()
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *server) listRepos(w http.ResponseWriter, req *http.Request) {
+	writeJSON(w, s.index())
+}
+
+func (s *server) rescanRepos(w http.ResponseWriter, req *http.Request) {
+	s.rescan()
+	writeJSON(w, s.index())
+}
+
+type statusJSON struct {
+	repoJSON
+	Branch    string     `json:"branch,omitempty"`
+	Head      string     `json:"head,omitempty"`
+	Bare      bool       `json:"bare,omitempty"`
+	Dirty     bool       `json:"dirty"`
+	Staged    int        `json:"staged"`
+	Modified  int        `json:"modified"`
+	Untracked int        `json:"untracked"`
+	Upstream  string     `json:"upstream,omitempty"`
+	Ahead     int        `json:"ahead"`
+	Behind    int        `json:"behind"`
+	Last      *time.Time `json:"last_commit,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+func (s *server) statusOf(st repoStatus) statusJSON {
+	js := statusJSON{
+		repoJSON:  repoJSON{s.rn.name(st.repo), st.repo.dir},
+		Branch:    st.branch,
+		Bare:      st.bare,
+		Dirty:     st.dirty,
+		Staged:    st.staged,
+		Modified:  st.modified,
+		Untracked: st.untracked,
+		Upstream:  st.upstream,
+		Ahead:     st.ahead,
+		Behind:    st.behind,
+	}
+	if !st.head.IsZero() {
+		js.Head = st.head.String()
+	}
+	if !st.last.IsZero() {
+		js.Last = &st.last
+	}
+	if st.err != nil {
+		js.Error = st.err.Error()
+	}
+	return js
+}
+
+// readRepos responds with the status of each selected repo, in order.
+func (s *server) readRepos(w http.ResponseWriter, req *http.Request) {
+	repos := s.selected()
+	states := make([]statusJSON, len(repos))
+	seq := make(map[int]int, len(repos))
+	for i, r := range repos {
+		seq[r.seq] = i
+	}
+	s.each(req.Context(), repos, func(r repo) {
+		st := readStatus(r)
+		s.metrics.read(st)
+		states[seq[r.seq]] = s.statusOf(st)
+	})
+	writeJSON(w, states)
+}
+
+// A runRequest is the command to run, and optionally, the repos to run in.
+type runRequest struct {
+	Command []string `json:"command"`
+	Shell   string   `json:"shell"`
+	Match   []string `json:"match"`
+}
+
+type resultJSON struct {
+	repoJSON
+	Status  string  `json:"status"`
+	Stdout  string  `json:"stdout"`
+	Stderr  string  `json:"stderr"`
+	Seconds float64 `json:"seconds"`
+}
+
+type sweepJSON struct {
+	Done      bool `json:"done"`
+	Succeeded int  `json:"succeeded"`
+	Failed    int  `json:"failed"`
+	Canceled  int  `json:"canceled"`
+}
+
+// runRepos runs the requested command in the selected repos, streaming the
+// result of each as a line of JSON, as it finishes, and then a summary.
+func (s *server) runRepos(w http.ResponseWriter, req *http.Request) {
+	// Browsers send other types across sites without asking first.
+	if t, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type")); t != "application/json" {
+		http.Error(w, "the body must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+	var run runRequest
+	if err := json.NewDecoder(req.Body).Decode(&run); err != nil {
+		http.Error(w, fmt.Sprintf("bad request: %v", err), http.StatusBadRequest)
+		return
+	}
+	rn := s.rn
+	switch {
+	case len(run.Command) > 0 && run.Shell != "":
+		http.Error(w, "only one of a command or shell can be run", http.StatusBadRequest)
+		return
+	case len(run.Command) > 0:
+		rn.cmds = [][]string{run.Command}
+	case run.Shell != "":
+		rn.cmds = [][]string{shellCommand(run.Shell)}
+	default:
+		http.Error(w, "no command to run", http.StatusBadRequest)
+		return
+	}
+	var others []filter
+	if len(run.Match) > 0 {
+		f, err := matchFilter(rn.roots, run.Match)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		others = append(others, f)
+	}
+	repos := s.selected(others...)
+
+	var lock sync.Mutex // Guards the following, and writing the response.
+	outputs := map[int][2][]byte{}
+	var sweep sweepJSON
+
+	rn.ctx = req.Context()
+	rn.results = newTally()
+	rn.sink = func(r repo, stdout, stderr []byte) {
+		lock.Lock()
+		defer lock.Unlock()
+		outputs[r.seq] = [2][]byte{stdout, stderr}
+	}
+	rn.found(len(repos))
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	s.each(req.Context(), repos, func(r repo) {
+		start := time.Now()
+		st := rn.execute(r)
+		s.metrics.ran(commandName(rn.cmds), st, time.Since(start))
+		lock.Lock()
+		defer lock.Unlock()
+		switch st {
+		case succeeded:
+			sweep.Succeeded++
+		case failed:
+			sweep.Failed++
+		}
+		out := outputs[r.seq]
+		delete(outputs, r.seq)
+		enc.Encode(resultJSON{
+			repoJSON: repoJSON{rn.name(r), r.dir},
+			Status:   st.String(),
+			Stdout:   string(out[0]),
+			Stderr:   string(out[1]),
+			Seconds:  time.Since(start).Seconds(),
+		})
+		if flusher != nil {
+			flusher.Flush()
+		}
+	})
+	lock.Lock()
+	defer lock.Unlock()
+	sweep.Done = true
+	sweep.Canceled = len(repos) - sweep.Succeeded - sweep.Failed
+	enc.Encode(sweep)
+}
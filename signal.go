@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// An interrupter cancels the run when git-walk, or a command it runs, is
+// interrupted.
+type interrupter struct {
+	lock   sync.Mutex
+	sig    os.Signal // The first signal received.
+	cancel context.CancelFunc
+}
+
+// trap interrupts on SIGINT or SIGTERM. A second signal exits immediately.
+func (i *interrupter) trap() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-c
+		fmt.Fprintf(os.Stderr, "%v: stopping, repeat to exit now\n", sig)
+		i.interrupt(sig)
+		sig = <-c
+		i.exit(sig)
+	}()
+}
+
+func (i *interrupter) interrupt(sig os.Signal) {
+	i.lock.Lock()
+	if i.sig == nil {
+		i.sig = sig
+	}
+	i.lock.Unlock()
+	i.cancel()
+}
+
+// signal returns the signal that interrupted the run, if any.
+func (i *interrupter) signal() os.Signal {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	return i.sig
+}
+
+// exit terminates git-walk with sig, so its parent knows it was signaled,
+// killing the commands running in their own process groups first, which the
+// signal, from the terminal, doesn't reach.
+func (i *interrupter) exit(sig os.Signal) {
+	groups.kill()
+	signal.Reset(sig)
+	raise(sig)
+	// In case the signal is not fatal.
+	if s, ok := sig.(syscall.Signal); ok {
+		os.Exit(128 + int(s))
+	}
+	os.Exit(1)
+}
+
+// groups are the commands running in process groups of their own.
+var groups = processGroups{live: map[*exec.Cmd]bool{}}
+
+// processGroups are commands running in process groups of their own, to be
+// killed, along with the processes they started, if git-walk exits first.
+type processGroups struct {
+	lock   sync.Mutex
+	live   map[*exec.Cmd]bool
+	killed bool // Once, any started later are killed at once.
+}
+
+// started notes that child, just started in a new process group, is running.
+func (g *processGroups) started(child *exec.Cmd) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.killed {
+		signalProcess(child, true, true)
+		return
+	}
+	g.live[child] = true
+}
+
+// exited notes that child has exited.
+func (g *processGroups) exited(child *exec.Cmd) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	delete(g.live, child)
+}
+
+// kill kills every command running, and its process group.
+func (g *processGroups) kill() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.killed = true
+	for child := range g.live {
+		signalProcess(child, true, true)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"os"
+)
+
+// spoolLimit is how much of a command's output is kept in memory, before the
+// rest is spilled to a temporary file.
+const spoolLimit = 1 << 20
+
+// A spool captures output, in memory, up to spoolLimit, and then in a
+// temporary file, so commands that write a lot, in parallel, don't use a lot
+// of memory. It can be written out any number of times, and must be closed
+// once it no longer is.
+type spool struct {
+	mem  []byte
+	file *os.File // The output after the first spoolLimit bytes, if any.
+	size int64    // The size of file.
+	name string   // The file's name, if it couldn't be removed while open.
+}
+
+// spoolOf returns a spool of b.
+func spoolOf(b []byte) *spool {
+	return &spool{mem: b}
+}
+
+func (s *spool) Write(b []byte) (int, error) {
+	if s.file == nil && len(s.mem)+len(b) <= spoolLimit {
+		s.mem = append(s.mem, b...)
+		return len(b), nil
+	}
+	if s.file == nil {
+		f, err := os.CreateTemp("", "git-walk-*.out")
+		if err != nil {
+			// Keep it all in memory, rather than lose it.
+			slog.Warn("spool failed", "err", err)
+			s.mem = append(s.mem, b...)
+			return len(b), nil
+		}
+		// Where it can be, remove it now, so it can't be left behind.
+		if os.Remove(f.Name()) != nil {
+			s.name = f.Name()
+		}
+		s.file = f
+	}
+	n, err := s.file.WriteAt(b, s.size)
+	s.size += int64(n)
+	return n, err
+}
+
+func (s *spool) WriteString(str string) (int, error) {
+	return s.Write([]byte(str))
+}
+
+// Len returns the size of the output.
+func (s *spool) Len() int64 {
+	return int64(len(s.mem)) + s.size
+}
+
+// WriteTo writes the output to w.
+func (s *spool) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(s.mem)
+	if err != nil || s.file == nil {
+		return int64(n), err
+	}
+	m, err := io.Copy(w, io.NewSectionReader(s.file, 0, s.size))
+	return int64(n) + m, err
+}
+
+// Bytes returns the output, all read into memory.
+func (s *spool) Bytes() []byte {
+	if s.file == nil {
+		return s.mem
+	}
+	b := make([]byte, 0, s.Len())
+	b = append(b, s.mem...)
+	rest := make([]byte, s.size)
+	n, _ := s.file.ReadAt(rest, 0)
+	return append(b, rest[:n]...)
+}
+
+// Close removes the spool's temporary file, if it has one.
+func (s *spool) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	if s.name != "" {
+		os.Remove(s.name)
+	}
+	s.file, s.size, s.name = nil, 0, ""
+	return err
+}
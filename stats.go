@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// runStats are the metrics of a run, written by --stats-json.
+type runStats struct {
+	Command     string    `json:"command"`       // The git-walk command.
+	Run         string    `json:"run,omitempty"` // The commands run in each repo.
+	Where       []string  `json:"where"`
+	Host        string    `json:"host"`
+	Started     time.Time `json:"started"`
+	Found       int       `json:"found"`
+	Ran         int       `json:"ran"` // Succeeded or failed.
+	Succeeded   int       `json:"succeeded"`
+	Failed      int       `json:"failed"`
+	Skipped     int       `json:"skipped"`
+	Pending     int       `json:"pending"` // Not processed, as the run was interrupted.
+	WalkErrors  int       `json:"walk_errors"`
+	Walk        float64   `json:"walk_seconds"`    // Until all repos were found.
+	Execute     float64   `json:"execute_seconds"` // From running in the first repo, until the last.
+	Total       float64   `json:"total_seconds"`
+	Concurrency int       `json:"concurrency"`
+	OutputBytes int64     `json:"output_bytes"` // Captured from commands, not written directly.
+	Interrupted bool      `json:"interrupted"`
+}
+
+// newRunStats returns the stats of running the command name in repos, as
+// results went, concurrency at once, in a run that started at start, and found
+// them all in walked.
+func newRunStats(rn *runner, name string, repos []repo, results *tally, concurrency int, start time.Time, walked time.Duration) *runStats {
+	host, _ := os.Hostname()
+	rs := &runStats{
+		Command:     name,
+		Run:         commandName(rn.cmds),
+		Host:        host,
+		Started:     start,
+		Found:       len(repos),
+		Succeeded:   len(results.with(repos, succeeded)),
+		Failed:      len(results.with(repos, failed)),
+		Skipped:     len(results.with(repos, skipped)),
+		Pending:     len(results.with(repos, pending)),
+		Walk:        walked.Seconds(),
+		Total:       time.Since(start).Seconds(),
+		Concurrency: concurrency,
+		Interrupted: rn.ctx.Err() != nil,
+	}
+	rs.Ran = rs.Succeeded + rs.Failed
+	for _, root := range rn.roots {
+		rs.Where = append(rs.Where, absDir(root))
+	}
+	results.lock.Lock()
+	defer results.lock.Unlock()
+	if !results.began.IsZero() {
+		rs.Execute = results.ended.Sub(results.began).Seconds()
+	}
+	rs.OutputBytes = results.output
+	rs.WalkErrors = results.walkErrors
+	return rs
+}
+
+// write writes the stats, as JSON, to path.
+func (rs *runStats) write(path string) error {
+	return writeJSONFile(path, rs)
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// A repoStatus is the state of a repo, as read directly from the repo by
+// go-git, rather than by running git.
+type repoStatus struct {
+	repo   repo
+	branch string // "" if HEAD is detached.
+	head   plumbing.Hash
+	bare   bool
+	dirty  bool
+	// Counts of the files that are staged, modified but not staged, and
+	// untracked.
+	staged, modified, untracked int
+	upstream                    string // The upstream branch, if there is one.
+	ahead                       int
+	behind                      int
+	last                        time.Time // When the HEAD commit was committed.
+	stashes                     int
+	stashed                     time.Time // When the oldest stash was made.
+	err                         error
+}
+
+func openRepo(dir string) (*git.Repository, error) {
+	return git.PlainOpenWithOptions(dir, &git.PlainOpenOptions{
+		EnableDotGitCommonDir: true,
+	})
+}
+
+// readHead reads the branch and HEAD commit of r, and returns the opened repo
+// to read more of its status from, unless there was an error.
+func readHead(r repo) (g *git.Repository, st repoStatus) {
+	st.repo = r
+	g, err := openRepo(r.dir)
+	if err != nil {
+		st.err = err
+		return nil, st
+	}
+	head, err := g.Storer.Reference(plumbing.HEAD)
+	if err != nil {
+		st.err = err
+		return nil, st
+	}
+	if head.Type() == plumbing.SymbolicReference {
+		st.branch = head.Target().Short()
+	}
+	if ref, err := g.Head(); err == nil {
+		st.head = ref.Hash()
+		if c, err := g.CommitObject(st.head); err == nil {
+			st.last = c.Committer.When
+		}
+	}
+	return g, st
+}
+
+// readStatus reads the status of r.
+func readStatus(r repo) repoStatus {
+	g, st := readHead(r)
+	if g == nil {
+		return st
+	}
+	readState(g, &st)
+	readStashes(r, &st)
+	return st
+}
+
+// readState reads whether the repo is dirty, and how it compares to its
+// upstream, into st.
+func readState(g *git.Repository, st *repoStatus) {
+	wt, err := g.Worktree()
+	if err == git.ErrIsBareRepository {
+		st.bare = true
+	} else if err != nil {
+		st.err = err
+		return
+	} else {
+		status, err := wt.Status()
+		if err != nil {
+			st.err = err
+			return
+		}
+		st.dirty = !status.IsClean()
+		for _, fs := range status {
+			switch {
+			case fs.Worktree == git.Untracked:
+				st.untracked++
+			case fs.Staging != git.Unmodified:
+				st.staged++
+			default:
+				st.modified++
+			}
+		}
+	}
+
+	readUpstream(g, st)
+}
+
+// readUpstream reads how the repo's branch compares to its upstream, into st.
+func readUpstream(g *git.Repository, st *repoStatus) {
+	if st.branch == "" || st.head.IsZero() {
+		return
+	}
+	upstream, ok := upstreamOf(g, st.branch)
+	if !ok {
+		return
+	}
+	st.upstream = upstream.Short()
+	ref, err := g.Reference(upstream, true)
+	if err != nil {
+		return
+	}
+	st.ahead, st.behind, err = aheadBehind(g, st.head, ref.Hash())
+	if err != nil {
+		st.err = err
+	}
+}
+
+// readStashes reads how many stashes r has, and when the oldest was made, from
+// the reflog of refs/stash, into st.
+func readStashes(r repo, st *repoStatus) {
+	shared := commonDir(r.dir)
+	if shared == "" {
+		shared = gitDirOf(r.dir)
+	}
+	data, err := os.ReadFile(filepath.Join(shared, "logs", "refs", "stash"))
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if line == "" {
+			continue
+		}
+		st.stashes++
+		// The oldest is first, each written as OLD NEW NAME <EMAIL> TIME TZ\tMESSAGE.
+		if st.stashes > 1 {
+			continue
+		}
+		who, _, _ := strings.Cut(line, "\t")
+		fields := strings.Fields(who)
+		if len(fields) >= 2 {
+			if secs, err := strconv.ParseInt(fields[len(fields)-2], 10, 64); err == nil {
+				st.stashed = time.Unix(secs, 0)
+			}
+		}
+	}
+}
+
+// upstreamOf returns the ref of the branch's upstream, if it has one.
+func upstreamOf(g *git.Repository, branch string) (plumbing.ReferenceName, bool) {
+	cfg, err := g.Config()
+	if err != nil {
+		return "", false
+	}
+	b := cfg.Branches[branch]
+	if b == nil || b.Merge == "" {
+		return "", false
+	}
+	if b.Remote == "" || b.Remote == "." {
+		return b.Merge, true
+	}
+	name := strings.TrimPrefix(b.Merge.String(), "refs/heads/")
+	return plumbing.NewRemoteReferenceName(b.Remote, name), true
+}
+
+// aheadBehind counts the commits in local but not upstream, and in upstream
+// but not local.
+func aheadBehind(g *git.Repository, local, upstream plumbing.Hash) (int, int, error) {
+	if local == upstream {
+		return 0, 0, nil
+	}
+	lc, err := g.CommitObject(local)
+	if err != nil {
+		return 0, 0, err
+	}
+	uc, err := g.CommitObject(upstream)
+	if err != nil {
+		return 0, 0, err
+	}
+	bases, err := lc.MergeBase(uc)
+	if err != nil {
+		return 0, 0, err
+	}
+	var ignore []plumbing.Hash
+	for _, b := range bases {
+		ignore = append(ignore, b.Hash)
+	}
+	count := func(c *object.Commit) (n int) {
+		object.NewCommitPreorderIter(c, nil, ignore).ForEach(func(*object.Commit) error {
+			n++
+			return nil
+		})
+		return
+	}
+	return count(lc), count(uc), nil
+}
+
+// dirtyReasons describes why st needs committing or pushing, if it does, or
+// has stashes. Branches without an upstream are left to doctor.
+func dirtyReasons(st repoStatus) []string {
+	var reasons []string
+	if st.err != nil {
+		return []string{"error: " + st.err.Error()}
+	}
+	count := func(n int, what string) {
+		if n > 0 {
+			reasons = append(reasons, fmt.Sprintf("%d %s", n, what))
+		}
+	}
+	count(st.staged, "staged")
+	count(st.modified, "modified")
+	count(st.untracked, "untracked")
+	count(st.ahead, "unpushed")
+	if st.stashes > 0 {
+		when := age(st.stashed)
+		if when != "now" {
+			when += " ago"
+		}
+		if st.stashes > 1 {
+			when = "the oldest " + when
+		}
+		reasons = append(reasons, fmt.Sprintf("%d stashed, %s", st.stashes, when))
+	}
+	return reasons
+}
+
+// age describes how long ago t was, briefly.
+func age(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	d := time.Since(t)
+	day := 24 * time.Hour
+	switch {
+	case d < time.Minute:
+		return "now"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	case d < day:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d < 14*day:
+		return fmt.Sprintf("%dd", d/day)
+	case d < 60*day:
+		return fmt.Sprintf("%dw", d/(7*day))
+	case d < 365*day:
+		return fmt.Sprintf("%dmo", d/(30*day))
+	}
+	return fmt.Sprintf("%dy", d/(365*day))
+}
+
+// A statusTable collects the status of repos, to be written as a table.
+type statusTable struct {
+	lock sync.Mutex
+	rows []repoStatus
+}
+
+func (t *statusTable) add(st repoStatus) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.rows = append(t.rows, st)
+}
+
+// write writes the table, ordered by repo path, naming repos with name.
+func (t *statusTable) write(w io.Writer, name func(repo) string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	sort.Slice(t.rows, func(i, j int) bool {
+		return t.rows[i].repo.dir < t.rows[j].repo.dir
+	})
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "REPO\tBRANCH\tSTATE\tUPSTREAM\tLAST COMMIT\n")
+	for _, st := range t.rows {
+		if st.err != nil {
+			fmt.Fprintf(tw, "%s\t-\terror: %v\t-\t-\n", name(st.repo), st.err)
+			continue
+		}
+		branch := st.branch
+		if branch == "" {
+			branch = "(detached)"
+		}
+		state := "clean"
+		switch {
+		case st.bare:
+			state = "bare"
+		case st.dirty:
+			state = "dirty"
+		}
+		upstream := "-"
+		if st.upstream != "" {
+			upstream = fmt.Sprintf("+%d -%d", st.ahead, st.behind)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", name(st.repo), branch, state, upstream, age(st.last))
+	}
+	tw.Flush()
+}
+
+// branchOf describes the branch st is on, or where its HEAD is detached.
+func branchOf(st repoStatus) string {
+	switch {
+	case st.branch != "":
+		return st.branch
+	case st.head.IsZero():
+		return "(no commits)"
+	}
+	return "(detached at " + st.head.String()[:7] + ")"
+}
+
+// writeBranches writes the branch of each repo, ordered by repo path, or if
+// grouped, the repos on each branch, ordered by branch.
+func (t *statusTable) writeBranches(w io.Writer, name func(repo) string, grouped bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	sort.Slice(t.rows, func(i, j int) bool {
+		return t.rows[i].repo.dir < t.rows[j].repo.dir
+	})
+	if !grouped {
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		for _, st := range t.rows {
+			if st.err != nil {
+				fmt.Fprintf(tw, "%s\terror: %v\n", name(st.repo), st.err)
+			} else {
+				fmt.Fprintf(tw, "%s\t%s\n", name(st.repo), branchOf(st))
+			}
+		}
+		tw.Flush()
+		return
+	}
+	groups := map[string][]string{}
+	var branches []string
+	for _, st := range t.rows {
+		b := branchOf(st)
+		if st.err != nil {
+			b = "(error)"
+		}
+		if groups[b] == nil {
+			branches = append(branches, b)
+		}
+		groups[b] = append(groups[b], name(st.repo))
+	}
+	sort.Strings(branches)
+	for _, b := range branches {
+		fmt.Fprintf(w, "%s (%d)\n", b, len(groups[b]))
+		for _, n := range groups[b] {
+			fmt.Fprintf(w, "    %s\n", n)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The status of a repo, once the command has been run in it.
+type status int
+
+const (
+	pending   status = iota // Not yet run, or canceled while running.
+	succeeded               // The command succeeded.
+	failed                  // The command failed, or timed out.
+	skipped                 // The repo was filtered out.
+)
+
+func (st status) String() string {
+	switch st {
+	case succeeded:
+		return "succeeded"
+	case failed:
+		return "failed"
+	case skipped:
+		return "skipped"
+	}
+	return "not processed"
+}
+
+// A tally records the status of each repo found.
+type tally struct {
+	lock    sync.Mutex
+	status  map[int]status        // By repo seq.
+	retries map[int]int           // By repo seq.
+	took    map[int]time.Duration // How long running in each repo took, by seq.
+
+	began, ended time.Time // When running in the first repo began, and the last ended.
+	output       int64     // Bytes of output captured.
+
+	walkErrors int      // Errors looking for repos.
+	uncloned   []string // The URLs of the repos clone failed to clone.
+}
+
+func newTally() *tally {
+	return &tally{
+		status:  make(map[int]status),
+		retries: make(map[int]int),
+		took:    make(map[int]time.Duration),
+	}
+}
+
+// timed records that running in r took d.
+func (t *tally) timed(r repo, d time.Duration) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.took[r.seq] += d
+	ended := time.Now()
+	if began := ended.Add(-d); t.began.IsZero() || began.Before(t.began) {
+		t.began = began
+	}
+	if ended.After(t.ended) {
+		t.ended = ended
+	}
+}
+
+// wrote records that n bytes of output were captured running in a repo.
+func (t *tally) wrote(n int64) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.output += n
+}
+
+// retried records that the command in r is being retried.
+func (t *tally) retried(r repo) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.retries[r.seq]++
+}
+
+func (t *tally) retriesOf(r repo) int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.retries[r.seq]
+}
+
+// tookIn returns how long running in r took, if it was run in.
+func (t *tally) tookIn(r repo) time.Duration {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.took[r.seq]
+}
+
+func (t *tally) record(r repo, st status) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.status[r.seq] = st
+}
+
+func (t *tally) statusOf(r repo) status {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.status[r.seq]
+}
+
+// with returns the repos with status st.
+func (t *tally) with(repos []repo, st status) []repo {
+	var with []repo
+	for _, r := range repos {
+		if t.statusOf(r) == st {
+			with = append(with, r)
+		}
+	}
+	return with
+}
+
+// summarize writes counts of repos by status, and lists those that failed or
+// were not processed, as written by path.
+func (t *tally) summarize(w io.Writer, repos []repo, elapsed time.Duration, path func(repo) string) {
+	ok := t.with(repos, succeeded)
+	bad := t.with(repos, failed)
+	skip := t.with(repos, skipped)
+	todo := t.with(repos, pending)
+	fmt.Fprintf(w, "%d repos in %v: %d succeeded, %d failed, %d skipped, %d not processed\n",
+		len(repos), elapsed.Round(time.Millisecond), len(ok), len(bad), len(skip), len(todo))
+	if t.walkErrors > 0 {
+		fmt.Fprintf(w, "%d errors looking for repos\n", t.walkErrors)
+	}
+	list := func(what string, repos []repo) {
+		if len(repos) == 0 {
+			return
+		}
+		fmt.Fprintf(w, "%s:\n", what)
+		for _, r := range repos {
+			fmt.Fprintf(w, "  %s\n", path(r))
+		}
+	}
+	list("failed", bad)
+	list("not processed", todo)
+	if len(t.uncloned) > 0 {
+		fmt.Fprintf(w, "not cloned:\n")
+		for _, url := range t.uncloned {
+			fmt.Fprintf(w, "  %s\n", url)
+		}
+	}
+
+	var retried []repo
+	for _, r := range repos {
+		if t.retriesOf(r) > 0 {
+			retried = append(retried, r)
+		}
+	}
+	if len(retried) > 0 {
+		fmt.Fprintf(w, "retried:\n")
+		for _, r := range retried {
+			fmt.Fprintf(w, "  %s (%d tries, %s)\n", path(r), t.retriesOf(r)+1, t.statusOf(r))
+		}
+	}
+}
+
+// timing writes the n slowest repos run in, as written by path, and the total
+// and median time running in each took.
+func (t *tally) timing(w io.Writer, repos []repo, n int, path func(repo) string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	var ran []repo
+	var total time.Duration
+	for _, r := range repos {
+		if d, ok := t.took[r.seq]; ok {
+			ran = append(ran, r)
+			total += d
+		}
+	}
+	if len(ran) == 0 {
+		return
+	}
+	sort.SliceStable(ran, func(i, j int) bool { return t.took[ran[i].seq] > t.took[ran[j].seq] })
+	median := t.took[ran[len(ran)/2].seq]
+	if len(ran)%2 == 0 {
+		median = (median + t.took[ran[len(ran)/2-1].seq]) / 2
+	}
+	if n > len(ran) {
+		n = len(ran)
+	}
+	fmt.Fprintf(w, "ran in %d repos for %v in all, median %v, slowest:\n",
+		len(ran), total.Round(time.Millisecond), median.Round(time.Millisecond))
+	for _, r := range ran[:n] {
+		fmt.Fprintf(w, "  %10v  %s (%s)\n", t.took[r.seq].Round(time.Millisecond), path(r), t.status[r.seq])
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A syncer reconciles a tree with a manifest, cloning the repos that are
+// missing, and fast-forwarding the repos that exist.
+type syncer struct {
+	root     string
+	listed   map[string]manifestRepo // The manifest's repos, by dir.
+	unlisted []repo                  // Repos found that the manifest doesn't list.
+}
+
+func newSyncer(root string, m *manifest) *syncer {
+	s := &syncer{root: root, listed: map[string]manifestRepo{}}
+	for _, mr := range m.Repos {
+		dir := filepath.FromSlash(mr.Path)
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(root, dir)
+		}
+		s.listed[filepath.Clean(dir)] = mr
+	}
+	return s
+}
+
+// repos returns the manifest's repos, ordered by path.
+func (s *syncer) repos() []repo {
+	var repos []repo
+	for dir := range s.listed {
+		repos = append(repos, repo{dir: dir})
+	}
+	sort.Slice(repos, func(i, j int) bool {
+		return repos[i].dir < repos[j].dir
+	})
+	return repos
+}
+
+// found records r, found in the tree, if the manifest doesn't list it.
+func (s *syncer) found(r repo) {
+	if _, ok := s.listed[r.dir]; !ok {
+		s.unlisted = append(s.unlisted, r)
+	}
+}
+
+// sync clones r if it is missing, or fetches it, and fast-forwards its branch
+// to its upstream.
+func (s *syncer) sync(ctx context.Context, r repo, stdout, stderr io.Writer) error {
+	mr := s.listed[r.dir]
+	if _, err := os.Stat(r.dir); os.IsNotExist(err) {
+		return cloneRepo(ctx, mr, r.dir, stdout, stderr)
+	}
+	if err := gitRun(ctx, r.dir, stdout, stderr, "fetch", "--all", "--prune"); err != nil {
+		return err
+	}
+	if _, err := gitOutput(r.dir, "rev-parse", "--abbrev-ref", "@{upstream}"); err != nil {
+		fmt.Fprintf(stdout, "no upstream to fast-forward to\n")
+		return nil
+	}
+	return gitRun(ctx, r.dir, stdout, stderr, "merge", "--ff-only", "@{upstream}")
+}
+
+// cloneRepo clones the manifest's repo into dir, from its origin, or its first
+// remote, adding its other remotes, and checking out its branch, or its HEAD
+// commit if it has no branch.
+func cloneRepo(ctx context.Context, mr manifestRepo, dir string, stdout, stderr io.Writer) error {
+	var names []string
+	for name := range mr.Remotes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return fmt.Errorf("no remote to clone %s from", mr.Path)
+	}
+	from := names[0]
+	if _, ok := mr.Remotes["origin"]; ok {
+		from = "origin"
+	}
+	args := []string{"clone", "--origin", from}
+	if mr.Branch != "" {
+		args = append(args, "--branch", mr.Branch)
+	}
+	args = append(args, mr.Remotes[from], dir)
+	if err := gitRun(ctx, filepath.Dir(dir), stdout, stderr, args...); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if name == from {
+			continue
+		}
+		if err := gitRun(ctx, dir, stdout, stderr, "remote", "add", "-f", name, mr.Remotes[name]); err != nil {
+			return err
+		}
+	}
+	if mr.Branch == "" && mr.Head != "" {
+		return gitRun(ctx, dir, stdout, stderr, "checkout", "--detach", mr.Head)
+	}
+	return nil
+}
+
+// gitRun runs git with args in dir, and writes the command and its output.
+func gitRun(ctx context.Context, dir string, stdout, stderr io.Writer, args ...string) error {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "git %s\n", strings.Join(args, " "))
+	git := commandContext(ctx, "git", args...)
+	git.Dir = dir
+	git.Stdout = stdout
+	git.Stderr = stderr
+	return runGroup(git)
+}
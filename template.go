@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// placeholders are replaced in each argument of the command with values for
+// the repo it is run in.
+var placeholders = []struct {
+	name  string
+	value func(r repo) string
+}{
+	{"{path}", func(r repo) string {
+		abs, err := filepath.Abs(r.dir)
+		if err != nil {
+			return r.dir
+		}
+		return abs
+	}},
+	{"{name}", func(r repo) string { return filepath.Base(r.dir) }},
+	{"{branch}", func(r repo) string { return currentBranch(r.dir) }},
+	{"{remote}", func(r repo) string { return originURL(r.dir) }},
+}
+
+// pathArg is replaced by the repo's path, as it was found, in commands run
+// where git-walk was run, with --here, rather than in the repo.
+const pathArg = "{}"
+
+// expand returns cmd with placeholders replaced by their values for r, and if
+// here, pathArg by its path. Values are only found for placeholders that are
+// used, and are quoted in the script of a shell, so they are each one word.
+func expand(cmd []string, r repo, here bool) []string {
+	script := scriptArg(cmd)
+	value := func(i int, v string) string {
+		if i == script {
+			return quoteWords([]string{v})
+		}
+		return v
+	}
+	var out []string
+	for i, arg := range cmd {
+		if here {
+			arg = strings.Replace(arg, pathArg, value(i, r.dir), -1)
+		}
+		for _, p := range placeholders {
+			if strings.Contains(arg, p.name) {
+				arg = strings.Replace(arg, p.name, value(i, p.value(r)), -1)
+			}
+		}
+		out = append(out, arg)
+	}
+	return out
+}
+
+// posixShells are the names of the shells whose scripts expand quotes.
+var posixShells = map[string]bool{"sh": true, "bash": true, "dash": true, "ksh": true, "zsh": true, "ash": true}
+
+// scriptArg returns the index of the argument of cmd that is a script, if cmd
+// runs a POSIX shell with -c, as --shell does, or otherwise -1.
+func scriptArg(cmd []string) int {
+	if len(cmd) < 3 || cmd[1] != "-c" {
+		return -1
+	}
+	if cmd[0] == os.Getenv("SHELL") || posixShells[filepath.Base(cmd[0])] {
+		return 2
+	}
+	return -1
+}
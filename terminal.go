@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+
+	"golang.org/x/term"
+)
+
+// A terminal is the user's terminal, apart from stdin and stdout, in raw mode,
+// so keys can be read from it as they are pressed.
+type terminal struct {
+	in, out *os.File
+	saved   *term.State
+	restore func() // Restores how out was written, once done.
+}
+
+// openTerminal opens the user's terminal, in raw mode.
+func openTerminal() (*terminal, error) {
+	in, err := os.OpenFile(ttyIn, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	out := in
+	if ttyOut != ttyIn {
+		if out, err = os.OpenFile(ttyOut, os.O_RDWR, 0); err != nil {
+			in.Close()
+			return nil, err
+		}
+	}
+	t := &terminal{in: in, out: out}
+	if t.saved, err = term.MakeRaw(int(in.Fd())); err != nil {
+		t.close()
+		return nil, err
+	}
+	t.restore = enableEscapes(out)
+	return t, nil
+}
+
+func (t *terminal) Read(b []byte) (int, error) {
+	return t.in.Read(b)
+}
+
+func (t *terminal) Write(b []byte) (int, error) {
+	return t.out.Write(b)
+}
+
+func (t *terminal) WriteString(s string) (int, error) {
+	return t.out.WriteString(s)
+}
+
+// size returns the width and height of the terminal.
+func (t *terminal) size() (int, int) {
+	width, height, _ := term.GetSize(int(t.out.Fd()))
+	return width, height
+}
+
+// Close restores the terminal to how it was, and closes it.
+func (t *terminal) Close() error {
+	t.restore()
+	term.Restore(int(t.in.Fd()), t.saved)
+	return t.close()
+}
+
+func (t *terminal) close() error {
+	if t.out != t.in {
+		t.out.Close()
+	}
+	return t.in.Close()
+}
@@ -0,0 +1,45 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// The user's terminal, to read from, and to write to.
+const (
+	ttyIn  = "/dev/tty"
+	ttyOut = "/dev/tty"
+)
+
+// enableEscapes has no need to do anything, since terminals interpret escape
+// sequences.
+func enableEscapes(f *os.File) (restore func()) {
+	return func() {}
+}
+
+// notifyResize sends to resized whenever t is resized, until stopped.
+func notifyResize(t *terminal, resized chan<- struct{}) (stop func()) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGWINCH)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-c:
+				select {
+				case resized <- struct{}{}:
+				default:
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(c)
+		close(done)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// The user's console, to read from, and to write to.
+const (
+	ttyIn  = "CONIN$"
+	ttyOut = "CONOUT$"
+)
+
+// enableEscapes makes the console f writes to interpret escape sequences, as
+// terminals do, if it is a console.
+func enableEscapes(f *os.File) (restore func()) {
+	h := windows.Handle(f.Fd())
+	var mode uint32
+	if windows.GetConsoleMode(h, &mode) != nil {
+		return func() {}
+	}
+	windows.SetConsoleMode(h, mode|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING)
+	return func() { windows.SetConsoleMode(h, mode) }
+}
+
+// notifyResize sends to resized whenever t is resized, until stopped. Consoles
+// don't signal being resized, so their size is checked now and then.
+func notifyResize(t *terminal, resized chan<- struct{}) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		tick := time.NewTicker(250 * time.Millisecond)
+		defer tick.Stop()
+		width, height := t.size()
+		for {
+			select {
+			case <-tick.C:
+				w, h := t.size()
+				if w == width && h == height {
+					continue
+				}
+				width, height = w, h
+				select {
+				case resized <- struct{}{}:
+				default:
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
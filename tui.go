@@ -0,0 +1,388 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A tui shows a run as a live table of its repos, that can be moved through,
+// to read the output of each repo, and to rerun or skip them.
+type tui struct {
+	name  func(repo) string
+	rerun func(repo) // Runs the command in a repo again.
+	quit  func()     // Stops the run, if it isn't done.
+
+	refresh chan struct{} // Signaled when the run's state has changed.
+
+	lock   sync.Mutex // Guards the following.
+	rows   []*tuiRow
+	bySeq  map[int]*tuiRow
+	done   bool           // Whether the run is done.
+	reruns sync.WaitGroup // Reruns still running.
+}
+
+// A tuiRow is the state of a repo in the run.
+type tuiRow struct {
+	repo    repo
+	status  status
+	running bool
+	skip    bool // Whether to skip the repo, once it is reached.
+	start   time.Time
+	took    time.Duration
+	out     []byte // The stdout and stderr of the repo's commands.
+}
+
+func newTUI(name func(repo) string) *tui {
+	return &tui{
+		name:    name,
+		refresh: make(chan struct{}, 1),
+		bySeq:   map[int]*tuiRow{},
+	}
+}
+
+// changed tells the view that the run's state has changed.
+func (ui *tui) changed() {
+	select {
+	case ui.refresh <- struct{}{}:
+	default:
+	}
+}
+
+// update calls f with the lock held, and then refreshes the view.
+func (ui *tui) update(f func()) {
+	ui.lock.Lock()
+	f()
+	ui.lock.Unlock()
+	ui.changed()
+}
+
+// add adds r, found, to the table.
+func (ui *tui) add(r repo) {
+	ui.update(func() {
+		row := &tuiRow{repo: r}
+		ui.rows = append(ui.rows, row)
+		ui.bySeq[r.seq] = row
+	})
+}
+
+// skipping reports whether r was marked to be skipped.
+func (ui *tui) skipping(r repo) bool {
+	ui.lock.Lock()
+	defer ui.lock.Unlock()
+	row := ui.bySeq[r.seq]
+	return row != nil && row.skip
+}
+
+// started records that the command started running in r.
+func (ui *tui) started(r repo) {
+	ui.update(func() {
+		if row := ui.bySeq[r.seq]; row != nil {
+			row.running, row.status, row.out = true, pending, nil
+			row.start = time.Now()
+		}
+	})
+}
+
+// finished records that the command finished in r, with st.
+func (ui *tui) finished(r repo, st status) {
+	ui.update(func() {
+		if row := ui.bySeq[r.seq]; row != nil {
+			row.running, row.status = false, st
+			if !row.start.IsZero() {
+				row.took = time.Since(row.start)
+			}
+		}
+	})
+}
+
+// output records the output of running in r. It is the runner's sink.
+func (ui *tui) output(r repo, stdout, stderr []byte) {
+	ui.update(func() {
+		if row := ui.bySeq[r.seq]; row != nil {
+			row.out = append(append(row.out, stdout...), stderr...)
+		}
+	})
+}
+
+// finish records that the run is done, but for any reruns.
+func (ui *tui) finish() {
+	ui.update(func() { ui.done = true })
+}
+
+// isDone reports whether the run is done.
+func (ui *tui) isDone() bool {
+	ui.lock.Lock()
+	defer ui.lock.Unlock()
+	return ui.done
+}
+
+// rerunSelected reruns the command in the i'th row, if it isn't running.
+func (ui *tui) rerunSelected(i int) {
+	ui.lock.Lock()
+	defer ui.lock.Unlock()
+	if i >= len(ui.rows) {
+		return
+	}
+	row := ui.rows[i]
+	if row.running || row.status == pending {
+		return
+	}
+	row.running = true
+	ui.reruns.Add(1)
+	go func() {
+		defer ui.reruns.Done()
+		ui.rerun(row.repo)
+	}()
+}
+
+// skipSelected marks the i'th row to be skipped, or not, if it hasn't started.
+func (ui *tui) skipSelected(i int) {
+	ui.update(func() {
+		if i < len(ui.rows) && !ui.rows[i].running && ui.rows[i].status == pending {
+			ui.rows[i].skip = !ui.rows[i].skip
+		}
+	})
+}
+
+// state describes the row's state, briefly.
+func (row *tuiRow) state() string {
+	switch {
+	case row.running:
+		return "running"
+	case row.status == pending && row.skip:
+		return "skip"
+	case row.status == pending:
+		return "waiting"
+	case row.status == succeeded:
+		return "ok"
+	}
+	return row.status.String()
+}
+
+// A tuiView is what the terminal shows of the run.
+type tuiView struct {
+	width, height int
+	cursor        int  // The selected row.
+	top           int  // The first row shown.
+	viewing       bool // Whether the selected row's output is shown.
+	line          int  // The first line of output shown.
+}
+
+// page is how many rows, or lines of output, fit between the header and the
+// footer.
+func (v *tuiView) page() int {
+	if v.height > 3 {
+		return v.height - 2
+	}
+	return 1
+}
+
+// run shows the tui on the terminal until the user quits, and then waits for
+// any reruns.
+func (ui *tui) run() error {
+	defer ui.reruns.Wait()
+	tty, err := openTerminal()
+	if err != nil {
+		return err
+	}
+	defer tty.Close()
+	// Use the alternate screen, without a cursor.
+	fmt.Fprint(tty, "\x1b[?1049h\x1b[?25l")
+	defer fmt.Fprint(tty, "\x1b[?25h\x1b[?1049l")
+
+	keys := make(chan string)
+	go readKeys(tty, keys)
+	resized := make(chan struct{}, 1)
+	defer notifyResize(tty, resized)()
+
+	var v tuiView
+	v.width, v.height = tty.size()
+	for {
+		ui.draw(tty, &v)
+		select {
+		case <-ui.refresh:
+			// Let a burst of changes be drawn at once.
+			time.Sleep(20 * time.Millisecond)
+		case <-resized:
+			v.width, v.height = tty.size()
+		case key, ok := <-keys:
+			if !ok || !ui.press(&v, key) {
+				ui.quit()
+				return nil
+			}
+		}
+	}
+}
+
+// readKeys sends the name of each key read from tty, until it can't be read.
+func readKeys(tty io.Reader, keys chan<- string) {
+	defer close(keys)
+	names := map[string]string{
+		"\x1b[A": "up", "\x1bOA": "up",
+		"\x1b[B": "down", "\x1bOB": "down",
+		"\x1b[5~": "pgup", "\x1b[6~": "pgdown",
+		"\x1b[H": "home", "\x1b[1~": "home",
+		"\x1b[F": "end", "\x1b[4~": "end",
+		"\x1b": "esc", "\r": "enter", "\n": "enter",
+		"\x03": "ctrl+c",
+	}
+	buf := make([]byte, 64)
+	for {
+		n, err := tty.Read(buf)
+		if err != nil {
+			return
+		}
+		in := string(buf[:n])
+		for in != "" {
+			key := in[:1]
+			if in[0] == '\x1b' && len(in) > 1 && (in[1] == '[' || in[1] == 'O') {
+				// An escape sequence, up to its final letter or ~.
+				if i := strings.IndexAny(in[2:], "ABCDFHPQRS~"); i >= 0 {
+					key = in[:i+3]
+				}
+			}
+			in = in[len(key):]
+			if name, ok := names[key]; ok {
+				key = name
+			}
+			keys <- key
+		}
+	}
+}
+
+// press acts on key, and returns false if the user quit.
+func (ui *tui) press(v *tuiView, key string) bool {
+	if v.viewing {
+		switch key {
+		case "ctrl+c":
+			return false
+		case "esc", "enter", "q":
+			v.viewing = false
+		case "up", "k":
+			v.line--
+		case "down", "j":
+			v.line++
+		case "pgup":
+			v.line -= v.page()
+		case "pgdown", " ":
+			v.line += v.page()
+		case "home", "g":
+			v.line = 0
+		case "end", "G":
+			v.line = 1 << 30
+		}
+		return true
+	}
+	switch key {
+	case "q", "ctrl+c":
+		return false
+	case "up", "k":
+		v.cursor--
+	case "down", "j":
+		v.cursor++
+	case "pgup":
+		v.cursor -= v.page()
+	case "pgdown":
+		v.cursor += v.page()
+	case "home", "g":
+		v.cursor = 0
+	case "end", "G":
+		v.cursor = 1 << 30
+	case "enter":
+		v.viewing, v.line = true, 0
+	case "r":
+		ui.rerunSelected(v.cursor)
+	case "s":
+		ui.skipSelected(v.cursor)
+	}
+	return true
+}
+
+// clamp returns n, limited to between 0 and max.
+func clamp(n, max int) int {
+	if n > max {
+		n = max
+	}
+	if n < 0 {
+		n = 0
+	}
+	return n
+}
+
+// draw draws the whole view on tty.
+func (ui *tui) draw(tty *terminal, v *tuiView) {
+	ui.lock.Lock()
+	defer ui.lock.Unlock()
+
+	page := v.page()
+	v.cursor = clamp(v.cursor, len(ui.rows)-1)
+	if v.cursor < v.top {
+		v.top = v.cursor
+	}
+	if v.cursor >= v.top+page {
+		v.top = v.cursor - page + 1
+	}
+
+	counts := map[status]int{}
+	running := 0
+	for _, row := range ui.rows {
+		if row.running {
+			running++
+		} else {
+			counts[row.status]++
+		}
+	}
+	state := "running"
+	if ui.done {
+		state = "done"
+	}
+	lines := []string{fmt.Sprintf("%d repos, %s: %d running, %d succeeded, %d failed, %d skipped, %d waiting",
+		len(ui.rows), state, running, counts[succeeded], counts[failed],
+		counts[skipped], counts[pending])}
+
+	help := "[up/down] move  [enter] output  [r] rerun  [s] skip  [q] quit"
+	if v.viewing && v.cursor < len(ui.rows) {
+		row := ui.rows[v.cursor]
+		out := strings.Split(strings.TrimSuffix(string(row.out), "\n"), "\n")
+		v.line = clamp(v.line, len(out)-page)
+		for i := v.line; i < len(out) && i < v.line+page; i++ {
+			lines = append(lines, out[i])
+		}
+		help = ui.name(row.repo) + "  [up/down] scroll  [esc] back"
+	} else {
+		v.viewing = false
+		for i := v.top; i < len(ui.rows) && i < v.top+page; i++ {
+			row := ui.rows[i]
+			mark := "  "
+			if i == v.cursor {
+				mark = "> "
+			}
+			took := ""
+			if row.took > 0 && !row.running {
+				took = row.took.Round(time.Millisecond).String()
+			}
+			lines = append(lines, fmt.Sprintf("%s%-8s %s  %s", mark, row.state(), ui.name(row.repo), took))
+		}
+	}
+	for len(lines) < page+1 {
+		lines = append(lines, "")
+	}
+	lines = append(lines, help)
+
+	var b strings.Builder
+	b.WriteString("\x1b[H")
+	for i, line := range lines {
+		if v.width > 0 && len(line) > v.width {
+			line = line[:v.width]
+		}
+		// Clear the rest of each line, and don't scroll past the last.
+		b.WriteString(strings.ReplaceAll(line, "\t", "    ") + "\x1b[K")
+		if i < len(lines)-1 {
+			b.WriteString("\r\n")
+		}
+	}
+	tty.WriteString(b.String())
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// A vcs is a version control system whose repos can be found, and run in.
+type vcs interface {
+	// name returns how the VCS is referred to, as in --vcs.
+	name() string
+	// kindOf returns the kind of repo dir, containing entries, is.
+	kindOf(dir string, entries []os.DirEntry) repoKind
+	// marker returns the name of the directory marking a repo, which isn't
+	// looked in for repos.
+	marker() string
+	// status returns the command run in the VCS's repos by default.
+	status() []string
+}
+
+// gitVCS is git, whose repos may also be bare.
+type gitVCS struct{}
+
+func (gitVCS) name() string { return "git" }
+
+func (gitVCS) kindOf(dir string, entries []os.DirEntry) repoKind { return kindOf(dir, entries) }
+
+func (gitVCS) marker() string { return ".git" }
+
+func (gitVCS) status() []string { return []string{"git", "status", "--short", "-b"} }
+
+// A markedVCS is a VCS whose repos are the directories with a directory of
+// its own in them, like .hg.
+type markedVCS struct {
+	vcsName string
+	dir     string   // Marks a repo.
+	cmd     []string // Run by default.
+}
+
+func (m markedVCS) name() string { return m.vcsName }
+
+func (m markedVCS) kindOf(dir string, entries []os.DirEntry) repoKind {
+	for _, e := range entries {
+		if e.Name() == m.dir && e.IsDir() {
+			return workRepo
+		}
+	}
+	return notRepo
+}
+
+func (m markedVCS) marker() string { return m.dir }
+
+func (m markedVCS) status() []string { return m.cmd }
+
+// vcses are the version control systems whose repos can be found, in the
+// order they are listed in.
+var vcses = []vcs{
+	gitVCS{},
+	markedVCS{"hg", ".hg", []string{"hg", "status"}},
+	markedVCS{"svn", ".svn", []string{"svn", "status"}},
+	markedVCS{"jj", ".jj", []string{"jj", "status"}},
+}
+
+// vcsNamed returns the VCS called name, or nil if there is none.
+func vcsNamed(name string) vcs {
+	for _, v := range vcses {
+		if v.name() == name {
+			return v
+		}
+	}
+	return nil
+}
+
+// parseVCS parses a comma-separated list of VCS names, as given to --vcs.
+func parseVCS(list string) ([]vcs, error) {
+	var vs []vcs
+	var names []string
+	for _, v := range vcses {
+		names = append(names, v.name())
+	}
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		v := vcsNamed(name)
+		if v == nil {
+			return nil, fmt.Errorf("%q is not one of %s", name, strings.Join(names, ", "))
+		}
+		vs = append(vs, v)
+	}
+	if len(vs) == 0 {
+		return nil, fmt.Errorf("%q names no VCS", list)
+	}
+	return vs, nil
+}
+
+// vcsOf returns the VCS of r.
+func vcsOf(r repo) vcs {
+	if v := vcsNamed(r.vcs); v != nil {
+		return v
+	}
+	return gitVCS{}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// The build of git-walk, set when built, as with:
+//
+//	go build -ldflags "-X main.version=1.2.0 -X main.commit=$(git rev-parse HEAD) -X main.date=$(date -u +%FT%TZ)"
+//
+// Those not set are taken from what go records of the build, if it can.
+var (
+	version = ""
+	commit  = ""
+	date    = ""
+)
+
+// versionString describes the build of git-walk.
+func versionString() string {
+	v, c, d, modified := version, commit, date, false
+	built := "built"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if v == "" && info.Main.Version != "(devel)" {
+			v = info.Main.Version
+		}
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if c == "" {
+					c = s.Value
+				}
+			case "vcs.time":
+				if d == "" {
+					d, built = s.Value, "committed"
+				}
+			case "vcs.modified":
+				modified = commit == "" && s.Value == "true"
+			}
+		}
+	}
+	if v == "" {
+		v = "devel"
+	}
+	parts := []string{"git-walk " + strings.TrimPrefix(v, "v")}
+	if c != "" {
+		if modified {
+			c += " (modified)"
+		}
+		parts = append(parts, "commit "+c)
+	}
+	if d != "" {
+		parts = append(parts, built+" "+d)
+	}
+	parts = append(parts, fmt.Sprintf("%s %s/%s", runtime.Version(), runtime.GOOS, runtime.GOARCH))
+	return strings.Join(parts, ", ")
+}
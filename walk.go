@@ -0,0 +1,520 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+)
+
+// A walker searches a directory tree for git repos.
+type walker struct {
+	root     string
+	maxDepth int      // Don't search below this depth, unless negative.
+	minDepth int      // Don't report repos above this depth.
+	follow   bool     // Follow symlinks to directories.
+	nested   bool     // Search for repos inside of repos.
+	bare     string   // Whether to "skip", "include", or "only" find bare repos.
+	excludes []string // Names of directories not to look in.
+	oneFS    bool     // Don't look in other filesystems than the root's.
+	skipFS   []string // Types of filesystem not to look in.
+
+	// What to do about errors walking: "ignore" them, "warn" of them, or
+	// warn, and "fail", calling failed.
+	errorPolicy string
+	failed      func()
+
+	submodules bool  // Find the initialized submodules of each repo found.
+	vcses      []vcs // Find the repos of these, or if none, of git.
+	jobs       int   // Read this many directories in parallel.
+
+	found func(r repo) // Called serially with each repo found.
+
+	ctx context.Context // Stop walking when done.
+
+	wg   sync.WaitGroup
+	sem  chan struct{} // Limits how many directories are read in parallel.
+	lock sync.Mutex    // Guards the following, and calls to found.
+	// Directories already visited, to detect symlink cycles.
+	visited map[fileID]bool
+	// Repos already found, so none are found twice.
+	reported map[string]bool
+
+	rootID fileID // The root, whose device is that of its filesystem.
+
+	errors int32 // Count of errors while walking, accessed atomically.
+}
+
+// A repo is a repo that was found, of git, unless --vcs found it of another.
+type repo struct {
+	dir   string
+	super string // The superproject's dir, if the repo is a submodule.
+	seq   int    // The order in which the repo was found.
+	vcs   string // The name of the repo's VCS, if it isn't git.
+	host  string // The host the repo is on, over ssh, if not this one.
+}
+
+// fileID uniquely identifies a file within a system.
+type fileID struct {
+	dev, ino uint64
+}
+
+type repoKind int
+
+const (
+	notRepo  repoKind = iota
+	workRepo          // A repo with a working tree, and a .git directory.
+	bareRepo          // A bare repo, with HEAD, objects, and refs.
+)
+
+// kindOf returns the kind of repo dir, containing entries, is.
+func kindOf(dir string, entries []os.DirEntry) repoKind {
+	var head, objects, refs bool
+	for _, e := range entries {
+		switch e.Name() {
+		case ".git":
+			if e.IsDir() || isGitFile(filepath.Join(dir, ".git")) {
+				return workRepo
+			}
+		case "HEAD":
+			head = e.Type().IsRegular()
+		case "objects":
+			objects = e.IsDir()
+		case "refs":
+			refs = e.IsDir()
+		}
+	}
+	if head && objects && refs {
+		return bareRepo
+	}
+	return notRepo
+}
+
+// isGitFile reports whether path is a .git file pointing at the repo of a
+// linked worktree or submodule checkout.
+func isGitFile(path string) bool {
+	data, err := os.ReadFile(path)
+	return err == nil && bytes.HasPrefix(data, []byte("gitdir: "))
+}
+
+func (w *walker) walk() {
+	info, err := os.Stat(w.root)
+	if err != nil {
+		w.errorf("walk %q failed with %v\n", w.root, err)
+		return
+	}
+	if !info.IsDir() {
+		return
+	}
+	w.visited = make(map[fileID]bool)
+	w.reported = make(map[string]bool)
+	w.rootID, _ = idOf(w.root)
+	// The walking goroutine is one of the jobs.
+	if w.jobs > 1 {
+		w.sem = make(chan struct{}, w.jobs-1)
+	}
+	w.visit(w.root, 0, nil)
+	w.wg.Wait()
+}
+
+// spawn visits the directory path in a new goroutine, or if too many are
+// already running, in this one.
+func (w *walker) spawn(path string, depth int, ignores []gitignore.Pattern) {
+	select {
+	case w.sem <- struct{}{}:
+		w.wg.Add(1)
+		go func() {
+			defer w.wg.Done()
+			w.visit(path, depth, ignores)
+			<-w.sem
+		}()
+	default:
+		w.visit(path, depth, ignores)
+	}
+}
+
+// visit reads the directory path once, reporting it if it is a repo, and
+// visiting its subdirectories, but for those ignores match.
+func (w *walker) visit(path string, depth int, ignores []gitignore.Pattern) {
+	if w.ctx.Err() != nil {
+		return
+	}
+	if w.follow && w.seen(path) {
+		slog.Debug("prune", "dir", path, "reason", "already visited")
+		return
+	}
+	if path != w.root && w.foreign(path) {
+		return
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		w.errorf("readdir %q failed with %s\n", path, err)
+		return
+	}
+	ignores, excluded := w.ignoresIn(path, entries, ignores)
+	if excluded {
+		slog.Debug("prune", "dir", path, "reason", "excluded")
+		return
+	}
+
+	if depth >= w.minDepth {
+		kind, v := w.kindOf(path, entries)
+		switch kind {
+		case workRepo:
+			if w.bare != "only" {
+				r := repo{dir: path}
+				if v.name() != "git" {
+					r.vcs = v.name()
+				}
+				w.report(r)
+				if w.submodules && r.vcs == "" {
+					w.findSubmodules(path)
+				}
+			}
+			if !w.nested {
+				return
+			}
+		case bareRepo:
+			if w.bare != "skip" {
+				w.report(repo{dir: path})
+			}
+			return
+		}
+	}
+	if w.maxDepth >= 0 && depth >= w.maxDepth {
+		return
+	}
+	ignore := gitignore.NewMatcher(ignores)
+	for _, e := range entries {
+		if w.marker(e.Name()) || w.excluded(e.Name()) {
+			continue
+		}
+		sub := filepath.Join(path, e.Name())
+		if len(ignores) > 0 && ignore.Match(w.components(sub), true) {
+			slog.Debug("prune", "dir", sub, "reason", "ignored")
+			continue
+		}
+		switch {
+		case e.IsDir():
+			w.spawn(sub, depth+1, ignores)
+		case e.Type()&os.ModeSymlink != 0 && w.follow:
+			info, err := os.Stat(sub)
+			if err != nil {
+				w.errorf("walk %q failed with %v\n", sub, err)
+			} else if info.IsDir() {
+				w.spawn(sub, depth+1, ignores)
+			}
+		}
+	}
+}
+
+// kindOf returns the kind of repo dir, containing entries, is, and its VCS,
+// the first of those found that it is a repo of.
+func (w *walker) kindOf(dir string, entries []os.DirEntry) (repoKind, vcs) {
+	if len(w.vcses) == 0 {
+		return kindOf(dir, entries), gitVCS{}
+	}
+	for _, v := range w.vcses {
+		if kind := v.kindOf(dir, entries); kind != notRepo {
+			return kind, v
+		}
+	}
+	return notRepo, nil
+}
+
+// marker reports whether directories called name mark a repo, and so aren't
+// looked in.
+func (w *walker) marker(name string) bool {
+	if len(w.vcses) == 0 {
+		return name == ".git"
+	}
+	for _, v := range w.vcses {
+		if name == v.marker() {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultExcludes are the names of directories not looked in for repos, by
+// default, since they are big, and rarely have repos worth running in.
+var defaultExcludes = []string{
+	"node_modules", "bower_components", "vendor", "target", ".terraform",
+	".cache", ".venv", "__pycache__", ".tox", ".gradle", "Pods",
+}
+
+// excluded reports whether directories called name are not looked in.
+func (w *walker) excluded(name string) bool {
+	for _, x := range w.excludes {
+		if name == x {
+			return true
+		}
+	}
+	return false
+}
+
+// Files that exclude the directory they are in from the walk, or if they have
+// patterns, as in .gitignore, the paths below it that they match.
+const (
+	noWalkFile     = ".nogitwalk"
+	walkIgnoreFile = ".gitwalkignore"
+)
+
+// ignoresIn returns ignores, and the patterns in the .gitwalkignore in path,
+// if any, and whether path is itself excluded. The root never is.
+func (w *walker) ignoresIn(path string, entries []os.DirEntry, ignores []gitignore.Pattern) ([]gitignore.Pattern, bool) {
+	// A .nogitwalk excludes path, whatever a .gitwalkignore says.
+	ignoreFile := false
+	for _, e := range entries {
+		switch e.Name() {
+		case noWalkFile:
+			return ignores, path != w.root
+		case walkIgnoreFile:
+			ignoreFile = true
+		}
+	}
+	if !ignoreFile {
+		return ignores, false
+	}
+	data, err := os.ReadFile(filepath.Join(path, walkIgnoreFile))
+	if err != nil {
+		w.errorf("read %q failed with %v\n", filepath.Join(path, walkIgnoreFile), err)
+		return ignores, false
+	}
+	domain := w.components(path)
+	var patterns []gitignore.Pattern
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, gitignore.ParsePattern(line, domain))
+	}
+	if len(patterns) == 0 {
+		return ignores, path != w.root
+	}
+	return append(ignores[:len(ignores):len(ignores)], patterns...), false
+}
+
+// components returns the path, relative to the root, split into its names.
+func (w *walker) components(path string) []string {
+	rel, err := filepath.Rel(w.root, path)
+	if err != nil || rel == "." {
+		return nil
+	}
+	return strings.Split(filepath.ToSlash(rel), "/")
+}
+
+// foreign reports whether the directory path is on a filesystem not to look
+// in: another than the root's, or one of a type skipped.
+func (w *walker) foreign(path string) bool {
+	if w.oneFS {
+		if id, ok := idOf(path); ok && id.dev != w.rootID.dev {
+			slog.Debug("prune", "dir", path, "reason", "on another filesystem")
+			return true
+		}
+	}
+	if len(w.skipFS) > 0 {
+		fs, ok := fsTypeOf(path)
+		for _, skip := range w.skipFS {
+			if ok && strings.HasPrefix(fs, skip) {
+				slog.Debug("prune", "dir", path, "reason", "on a skipped filesystem", "fs", fs)
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// seen reports whether the directory path was already visited, and marks it
+// as visited.
+func (w *walker) seen(path string) bool {
+	id, ok := idOf(path)
+	if !ok {
+		return false
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.visited[id] {
+		return true
+	}
+	w.visited[id] = true
+	return false
+}
+
+// errorf reports an error while walking, unless they are ignored.
+func (w *walker) errorf(format string, args ...interface{}) {
+	atomic.AddInt32(&w.errors, 1)
+	if w.errorPolicy == "ignore" {
+		slog.Debug("walk error", "err", strings.TrimSpace(fmt.Sprintf(format, args...)))
+		return
+	}
+	fmt.Fprintf(os.Stderr, format, args...)
+	if w.errorPolicy == "fail" && w.failed != nil {
+		w.failed()
+	}
+}
+
+// failures returns the number of errors while walking.
+func (w *walker) failures() int {
+	return int(atomic.LoadInt32(&w.errors))
+}
+
+func (w *walker) report(r repo) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.reported[r.dir] {
+		return
+	}
+	w.reported[r.dir] = true
+	w.found(r)
+}
+
+// findSubmodules reports the initialized submodules of the repo at dir,
+// recursively.
+func (w *walker) findSubmodules(dir string) {
+	out, err := gitOutput(dir, "submodule", "status", "--recursive")
+	if err != nil {
+		w.errorf("submodule status %q failed with %v\n", dir, err)
+		return
+	}
+	for _, line := range strings.Split(out, "\n") {
+		// Lines are "<flag><sha1> <path> ...", where a flag of "-" means
+		// the submodule is not initialized.
+		f := strings.Fields(line)
+		if len(f) < 2 || strings.HasPrefix(line, "-") {
+			continue
+		}
+		w.report(repo{dir: filepath.Join(dir, f[1]), super: dir})
+	}
+}
+
+// readRepos reports each repo listed in r, one per line, or NUL-terminated
+// as written by --list -0.
+func readRepos(r io.Reader, found func(r repo)) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	sep := "\n"
+	if bytes.IndexByte(data, 0) >= 0 {
+		sep = "\x00"
+	}
+	for _, line := range strings.Split(string(data), sep) {
+		if line = strings.TrimRight(line, "\r"); line == "" {
+			continue
+		}
+		found(repo{dir: filepath.Clean(line)})
+	}
+	return nil
+}
+
+// sortByPath sorts repos by their paths, as walking one directory at a time
+// finds them: those in each directory by name, each before those below it.
+func sortByPath(repos []repo) {
+	sort.Slice(repos, func(i, j int) bool {
+		a := strings.Split(filepath.ToSlash(repos[i].dir), "/")
+		b := strings.Split(filepath.ToSlash(repos[j].dir), "/")
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return len(a) < len(b)
+	})
+}
+
+// foundOnce returns a found func calling found with each repo only the first
+// time it is found, as it can be when the roots walked overlap.
+func foundOnce(found func(r repo)) func(r repo) {
+	seen := map[string]bool{}
+	return func(r repo) {
+		key := r.dir
+		if abs, err := filepath.Abs(r.dir); err == nil {
+			key = abs
+		}
+		if !seen[key] {
+			seen[key] = true
+			found(r)
+		}
+	}
+}
+
+// expandRoots returns roots, with a ~ at the start of any replaced by the home
+// directory, and any globs replaced by the directories they match, so they can
+// be given where the shell wouldn't expand them.
+func expandRoots(roots []string) ([]string, error) {
+	var expanded []string
+	for _, root := range roots {
+		root = expandHome(root)
+		if !strings.ContainsAny(root, "*?[") {
+			expanded = append(expanded, root)
+			continue
+		}
+		matches, err := filepath.Glob(root)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %v", root, err)
+		}
+		n := len(expanded)
+		for _, m := range matches {
+			if info, err := os.Stat(m); err == nil && info.IsDir() {
+				expanded = append(expanded, m)
+			}
+		}
+		if len(expanded) == n {
+			return nil, fmt.Errorf("%q matches no directories", root)
+		}
+	}
+	return expanded, nil
+}
+
+// uniqueRoots returns roots, without any that are the same directory as one
+// before it.
+func uniqueRoots(roots []string) []string {
+	var unique []string
+	seen := map[string]bool{}
+	for _, root := range roots {
+		key := filepath.Clean(root)
+		if abs, err := filepath.Abs(root); err == nil {
+			key = abs
+		}
+		if !seen[key] {
+			seen[key] = true
+			unique = append(unique, root)
+		}
+	}
+	return unique
+}
+
+// rootOf returns the root dir was found in: the deepest of roots it is in, or
+// if it is in none of them, the first.
+func rootOf(roots []string, dir string) string {
+	abs := func(path string) string {
+		if abs, err := filepath.Abs(path); err == nil {
+			return abs
+		}
+		return filepath.Clean(path)
+	}
+	found, longest := roots[0], -1
+	dir = abs(dir)
+	for _, root := range roots {
+		path := abs(root)
+		rel, err := filepath.Rel(path, dir)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if len(path) > longest {
+			found, longest = root, len(path)
+		}
+	}
+	return found
+}
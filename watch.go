@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+)
+
+// A watcher reruns in repos when their working trees, or their refs, change.
+// Changes are debounced, so a burst of them causes one rerun, once they have
+// stopped for its delay, and changes while a repo is being run in, which are
+// likely made by the command, are ignored.
+type watcher struct {
+	ctx   context.Context
+	fs    *fsnotify.Watcher
+	delay time.Duration
+	rerun func(repo)
+	jobs  chan struct{}  // Limits how many reruns run at once.
+	wg    sync.WaitGroup // Reruns still running.
+
+	lock    sync.Mutex // Guards the following.
+	dirs    map[string]watched
+	timers  map[string]*time.Timer // Reruns waiting for changes to stop, by repo dir.
+	running map[string]bool        // Repos being run in, by dir.
+}
+
+// A watched directory, and the repo it is in.
+type watched struct {
+	repo   repo
+	ignore gitignore.Matcher // Nil for dirs in the git dir.
+}
+
+func newWatcher(ctx context.Context, delay time.Duration, jobs int, rerun func(repo)) (*watcher, error) {
+	fs, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	return &watcher{
+		ctx:     ctx,
+		fs:      fs,
+		delay:   delay,
+		rerun:   rerun,
+		jobs:    make(chan struct{}, jobs),
+		dirs:    map[string]watched{},
+		timers:  map[string]*time.Timer{},
+		running: map[string]bool{},
+	}, nil
+}
+
+// add watches r's working tree, except for what git ignores, and its git dir
+// and refs.
+func (w *watcher) add(r repo) error {
+	gitDir := filepath.Join(r.dir, ".git")
+	if fi, err := os.Stat(gitDir); err != nil {
+		// A bare repo.
+		gitDir = r.dir
+	} else {
+		if !fi.IsDir() {
+			// A worktree, or a submodule, with its git dir elsewhere.
+			if gitDir, err = gitOutput(r.dir, "rev-parse", "--absolute-git-dir"); err != nil {
+				return err
+			}
+		}
+		ps, _ := gitignore.ReadPatterns(osfs.New(r.dir), nil)
+		if err := w.addTree(r, r.dir, gitignore.NewMatcher(ps)); err != nil {
+			return err
+		}
+	}
+	if err := w.watch(gitDir, watched{repo: r}); err != nil {
+		return err
+	}
+	refs := filepath.Join(gitDir, "refs")
+	if _, err := os.Stat(refs); os.IsNotExist(err) {
+		return nil
+	}
+	return w.addTree(r, refs, nil)
+}
+
+// addTree watches the dirs at root and below, but for those ignored, git
+// dirs, and other repos.
+func (w *watcher) addTree(r repo, root string, ignore gitignore.Matcher) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		if path != root && ignore != nil {
+			if _, err := os.Lstat(filepath.Join(path, ".git")); err == nil || d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			rel, _ := filepath.Rel(r.dir, path)
+			if ignore.Match(strings.Split(filepath.ToSlash(rel), "/"), true) {
+				return filepath.SkipDir
+			}
+		}
+		return w.watch(path, watched{repo: r, ignore: ignore})
+	})
+}
+
+func (w *watcher) watch(dir string, wd watched) error {
+	if err := w.fs.Add(dir); err != nil {
+		return err
+	}
+	w.lock.Lock()
+	w.dirs[dir] = wd
+	w.lock.Unlock()
+	return nil
+}
+
+// run reruns in repos as they change, until ctx is done, and then waits for
+// the reruns.
+func (w *watcher) run() {
+	defer w.wg.Wait()
+	defer w.fs.Close()
+	for {
+		select {
+		case <-w.ctx.Done():
+			// Reruns are only started with the lock held, before ctx is done.
+			w.lock.Lock()
+			w.lock.Unlock()
+			return
+		case ev := <-w.fs.Events:
+			w.event(ev)
+		case err := <-w.fs.Errors:
+			fmt.Fprintf(os.Stderr, "watch failed with %v\n", err)
+		}
+	}
+}
+
+func (w *watcher) event(ev fsnotify.Event) {
+	if strings.HasSuffix(ev.Name, ".lock") {
+		return
+	}
+	w.lock.Lock()
+	wd, ok := w.dirs[filepath.Dir(ev.Name)]
+	w.lock.Unlock()
+	if !ok {
+		return
+	}
+	if wd.ignore != nil {
+		fi, err := os.Stat(ev.Name)
+		isDir := err == nil && fi.IsDir()
+		rel, _ := filepath.Rel(wd.repo.dir, ev.Name)
+		if wd.ignore.Match(strings.Split(filepath.ToSlash(rel), "/"), isDir) {
+			return
+		}
+		if ev.Has(fsnotify.Create) && isDir {
+			if err := w.addTree(wd.repo, ev.Name, wd.ignore); err != nil {
+				fmt.Fprintf(os.Stderr, "watch %q failed with %v\n", ev.Name, err)
+			}
+		}
+	}
+	slog.Debug("watch", "repo", wd.repo.dir, "event", ev)
+	if ev.Has(fsnotify.Remove) || ev.Has(fsnotify.Rename) {
+		w.lock.Lock()
+		delete(w.dirs, ev.Name)
+		w.lock.Unlock()
+	}
+	w.changed(wd.repo)
+}
+
+// changed reruns in r once it stops changing, unless it is being run in.
+func (w *watcher) changed(r repo) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.running[r.dir] {
+		return
+	}
+	if t := w.timers[r.dir]; t != nil {
+		t.Reset(w.delay)
+		return
+	}
+	w.timers[r.dir] = time.AfterFunc(w.delay, func() {
+		w.lock.Lock()
+		delete(w.timers, r.dir)
+		if w.ctx.Err() != nil {
+			w.lock.Unlock()
+			return
+		}
+		w.running[r.dir] = true
+		w.wg.Add(1)
+		w.lock.Unlock()
+
+		w.jobs <- struct{}{}
+		w.rerun(r)
+		<-w.jobs
+
+		w.lock.Lock()
+		delete(w.running, r.dir)
+		w.lock.Unlock()
+		w.wg.Done()
+	})
+}
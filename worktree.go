@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// linkedGitDir returns the git dir the .git file in dir links to, or "" if
+// there is no .git file, as there isn't in most repos.
+func linkedGitDir(dir string) string {
+	data, err := os.ReadFile(filepath.Join(dir, ".git"))
+	if err != nil || !bytes.HasPrefix(data, []byte("gitdir: ")) {
+		return ""
+	}
+	gitDir := string(bytes.TrimSpace(data[len("gitdir: "):]))
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(dir, gitDir)
+	}
+	return gitDir
+}
+
+// gitDirOf returns the git dir of the repo at dir.
+func gitDirOf(dir string) string {
+	if gitDir := linkedGitDir(dir); gitDir != "" {
+		return gitDir
+	}
+	if info, err := os.Stat(filepath.Join(dir, ".git")); err == nil && info.IsDir() {
+		return filepath.Join(dir, ".git")
+	}
+	return dir // A bare repo.
+}
+
+// commonDir returns the git dir shared by the linked worktree at dir, and
+// its other worktrees, or "" if dir isn't a linked worktree.
+func commonDir(dir string) string {
+	gitDir := linkedGitDir(dir)
+	if gitDir == "" {
+		return ""
+	}
+	// Submodule checkouts have .git files too, but no commondir.
+	common, err := os.ReadFile(filepath.Join(gitDir, "commondir"))
+	if err != nil {
+		return ""
+	}
+	path := string(bytes.TrimSpace(common))
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(gitDir, path)
+	}
+	return filepath.Clean(path)
+}
+
+// primaryOf returns the main worktree of the repo whose git dir is common,
+// or the repo itself, if it is bare.
+func primaryOf(common string) string {
+	if filepath.Base(common) == ".git" {
+		return filepath.Dir(common)
+	}
+	return common
+}
+
+// worktreesFound returns a found func calling found with the repos found
+// as --worktrees says: "all" of them, those that aren't linked worktrees,
+// with "skip-linked", or with "primary-only", each repo's main worktree in
+// place of its linked ones, once.
+func worktreesFound(policy string, found func(r repo)) func(r repo) {
+	switch policy {
+	case "skip-linked":
+		return func(r repo) {
+			if r.vcs == "" && commonDir(r.dir) != "" {
+				slog.Debug("skip", "repo", r.dir, "reason", "a linked worktree")
+				return
+			}
+			found(r)
+		}
+	case "primary-only":
+		once := foundOnce(found)
+		return func(r repo) {
+			if r.vcs == "" {
+				if common := commonDir(r.dir); common != "" {
+					slog.Debug("worktree", "repo", r.dir, "primary", primaryOf(common))
+					r.dir = primaryOf(common)
+				}
+			}
+			once(r)
+		}
+	}
+	return found
+}